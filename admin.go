@@ -0,0 +1,505 @@
+package que
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+// PeekNext returns the job that is next in line to be worked in queue,
+// following the Client's LockOrder like LockJob, or nil if no job is ready or
+// the queue is paused. Jobs whose dependencies are pending are skipped.
+// It takes no advisory lock and holds no connection, so it never affects
+// work: the returned Job is a snapshot that may already be locked by a
+// worker, and it cannot be deleted, errored or marked as done.
+func (c *Client) PeekNext(ctx context.Context, queue string) (*Job, error) {
+	j := &Job{}
+	err := scanJob(c.pool.QueryRowEx(ctx, c.orderedStatement("que_peek_job"), nil, queue), j)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// JobDetails is a read-only snapshot of a job, as returned by GetJob. Unlike
+// a Job it cannot be locked, deleted or errored.
+type JobDetails struct {
+	ID                int64
+	Queue             string
+	Priority          int16
+	RunAt             time.Time
+	Type              string
+	Args              []byte
+	ErrorCount        int32
+	LastError         pgtype.Text
+	Tags              map[string]string
+	DependsOn         []int64
+	ExpiresAt         time.Time
+	Deadline          time.Time
+	GroupID           string
+	CompletionWebhook string
+
+	// LockedBy is the ID of the Worker working the job, if it set one.
+	LockedBy pgtype.Text
+
+	// WorkedByLabels are the Labels of the Worker that last worked the job,
+	// if it had any.
+	WorkedByLabels map[string]string
+
+	// Locked reports whether a worker holds the job's advisory lock, using
+	// the Client's AdvisoryLockClass.
+	Locked bool
+}
+
+// GetJob returns the details of the job with the given ID, or nil if there is
+// no such job, for instance because it was already worked.
+func (c *Client) GetJob(ctx context.Context, id int64) (*JobDetails, error) {
+	d, err := scanJobDetails(c.pool.QueryRowEx(ctx, "que_get_job", nil, id, c.AdvisoryLockClass))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return d, err
+}
+
+// JobFilter selects the jobs returned by ListJobs. The zero value selects
+// the jobs of the default queue.
+type JobFilter struct {
+	// Queue is the queue to list, unless AllQueues is set.
+	Queue     string
+	AllQueues bool
+
+	// Type, if set, only selects jobs of that type.
+	Type string
+
+	// MinErrorCount and MaxErrorCount bound the ErrorCount of the selected
+	// jobs. A MaxErrorCount of zero sets no upper bound.
+	MinErrorCount int32
+	MaxErrorCount int32
+
+	// Failing, if set, only selects jobs that failed at least once.
+	Failing bool
+}
+
+// ListJobs returns a page of the jobs matching filter in the order they were
+// enqueued, skipping the first offset and returning at most limit of them,
+// or all of them if limit is zero or less, along with the total number of
+// matching jobs. The page and the total are read by two queries, so they may
+// disagree while jobs are enqueued or worked.
+func (c *Client) ListJobs(ctx context.Context, filter JobFilter, limit, offset int) ([]*JobDetails, int, error) {
+	queue := &pgtype.Text{String: filter.Queue, Status: pgtype.Present}
+	if filter.AllQueues {
+		queue.Status = pgtype.Null
+	}
+	jobType := &pgtype.Text{String: filter.Type, Status: pgtype.Null}
+	if filter.Type != "" {
+		jobType.Status = pgtype.Present
+	}
+	maxErrors := &pgtype.Int4{Int: filter.MaxErrorCount, Status: pgtype.Null}
+	if filter.MaxErrorCount > 0 {
+		maxErrors.Status = pgtype.Present
+	}
+	pageLimit := &pgtype.Int8{Int: int64(limit), Status: pgtype.Null}
+	if limit > 0 {
+		pageLimit.Status = pgtype.Present
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	params := []interface{}{queue, jobType, filter.MinErrorCount, maxErrors, filter.Failing}
+
+	var total int
+	if err := c.pool.QueryRowEx(ctx, "que_count_jobs", nil, params...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := c.pool.QueryEx(ctx, "que_list_jobs", nil, append(params, c.AdvisoryLockClass, pageLimit, int64(offset))...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var jobs []*JobDetails
+	for rows.Next() {
+		d, err := scanJobDetails(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		jobs = append(jobs, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return jobs, total, nil
+}
+
+// scanJobDetails scans a row of sqlGetJob or sqlListJobs.
+func scanJobDetails(row rowScanner) (*JobDetails, error) {
+	var j Job
+	var labels pgtype.JSONB
+	d := &JobDetails{}
+	if err := scanJob(row, &j, &d.LockedBy, &d.DependsOn, &labels, &d.Locked); err != nil {
+		return nil, err
+	}
+	if labels.Status == pgtype.Present {
+		if err := labels.AssignTo(&d.WorkedByLabels); err != nil {
+			return nil, err
+		}
+	}
+	d.ID = j.ID
+	d.Queue = j.Queue
+	d.Priority = j.Priority
+	d.RunAt = j.RunAt
+	d.Type = j.Type
+	d.Args = j.Args
+	d.ErrorCount = j.ErrorCount
+	d.LastError = j.LastError
+	d.Tags = j.Tags
+	d.ExpiresAt = j.ExpiresAt
+	d.Deadline = j.Deadline
+	d.GroupID = j.GroupID
+	d.CompletionWebhook = j.CompletionWebhook
+	return d, nil
+}
+
+// defaultWaitPollInterval is the poll interval of WaitForJob when none is
+// given.
+const defaultWaitPollInterval = 100 * time.Millisecond
+
+// WaitForJob blocks until the job with the given ID no longer exists, which
+// is the case once it was worked successfully or deleted, checking every
+// pollInterval (100ms if zero or less). It returns nil right away if there is
+// no such job, and ctx.Err() if ctx is done first. A job that keeps failing is
+// never deleted, so use a ctx with a deadline. It is mostly meant for
+// integration tests and simple enqueue-and-wait flows.
+func (c *Client) WaitForJob(ctx context.Context, id int64, pollInterval time.Duration) error {
+	if pollInterval <= 0 {
+		pollInterval = defaultWaitPollInterval
+	}
+	for {
+		var exists bool
+		if err := c.pool.QueryRowEx(ctx, "que_job_exists", nil, id).Scan(&exists); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if !exists {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// ErrLockHeldElsewhere is returned by ForceUnlock when the job's advisory
+// lock is held by a session it cannot release it from.
+var ErrLockHeldElsewhere = errors.New("job lock is held by another session")
+
+// ForceUnlock releases the advisory lock on the job with the given ID that
+// was left behind, for instance by a WorkFunc that never called Done, so
+// that the job can be worked again. It returns nil if the job is not locked.
+//
+// Advisory locks belong to the database session that took them, so
+// ForceUnlock can only release a lock held by the connection of the Client's
+// pool it runs on. Otherwise it returns an error wrapping
+// ErrLockHeldElsewhere that names the backend PID holding the lock; ending
+// that backend with pg_terminate_backend releases the lock, along with
+// everything else the session holds. Never force unlock a job that is still
+// being worked, or it can be worked twice.
+func (c *Client) ForceUnlock(ctx context.Context, id int64) error {
+	conn, err := c.pool.AcquireEx(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.pool.Release(conn)
+
+	var ok bool
+	if err := conn.QueryRowEx(ctx, "que_unlock_job", nil, id, c.AdvisoryLockClass).Scan(&ok); err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+
+	var pid int32
+	err = conn.QueryRowEx(ctx, "que_lock_holder", nil, id, c.AdvisoryLockClass).Scan(&pid)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: backend %d", ErrLockHeldElsewhere, pid)
+}
+
+// CountByType returns the number of jobs of type jobType in queue, including
+// ones that are being worked. It can be used to verify that no jobs of an
+// obsolete type remain before its WorkFunc is removed.
+func (c *Client) CountByType(ctx context.Context, queue, jobType string) (int, error) {
+	var n int
+	err := c.pool.QueryRowEx(ctx, "que_count_by_type", nil, queue, jobType).Scan(&n)
+	return n, err
+}
+
+// AdvisoryLockCount returns the number of advisory locks on jobs of the
+// Client's AdvisoryLockClass held by the sessions of the current database,
+// i.e. the jobs being worked plus any leaked locks. Alerting when it grows
+// well beyond the number of workers catches leaks before the server runs out
+// of lock slots, which max_locks_per_transaction bounds. Leaked locks are
+// commonly those of jobs deleted without calling Done, so the locks are not
+// matched against que_jobs. With the default class of zero, the locks of job
+// IDs of 2^32 and above are not counted, and any single-key advisory lock the
+// application takes below 2^32 is, so set an AdvisoryLockClass to count only
+// que's locks. Locks taken by a SkipLockedLocker are row locks and are not
+// counted either.
+func (c *Client) AdvisoryLockCount(ctx context.Context) (int, error) {
+	var n int
+	err := c.pool.QueryRowEx(ctx, "que_advisory_lock_count", nil, c.AdvisoryLockClass).Scan(&n)
+	return n, err
+}
+
+// ErrJobLocked is returned by SetPriority when the job is being worked.
+var ErrJobLocked = errors.New("job is locked")
+
+// SetPriority changes the priority of the job with the given ID, so a job
+// that turned out to be more urgent is locked sooner without re-enqueueing
+// it. It returns ErrJobLocked, changing nothing, if a worker holds the job's
+// advisory lock, and nil if there is no such job.
+func (c *Client) SetPriority(ctx context.Context, id int64, priority int16) error {
+	if priority < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidPriority, priority)
+	}
+	tag, err := c.pool.ExecEx(ctx, "que_set_priority", nil, id, priority, c.AdvisoryLockClass)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+
+	var exists bool
+	if err := c.pool.QueryRowEx(ctx, "que_job_exists", nil, id).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrJobLocked
+	}
+	return nil
+}
+
+// ScheduledWithin returns the number of jobs in queue that become ready
+// within window from now, not counting jobs that are ready already, for
+// instance to add workers ahead of a burst of delayed jobs. Like
+// DeleteOlderThan it uses the database clock.
+func (c *Client) ScheduledWithin(ctx context.Context, queue string, window time.Duration) (int, error) {
+	var n int
+	err := c.pool.QueryRowEx(ctx, "que_scheduled_within", nil, queue, window.Seconds()).Scan(&n)
+	return n, err
+}
+
+// GroupRemaining returns the number of jobs with the given GroupID that are
+// still enqueued, including ones that are being worked or waiting to be
+// retried. It is zero once the whole group is done.
+func (c *Client) GroupRemaining(ctx context.Context, groupID string) (int, error) {
+	var n int
+	err := c.pool.QueryRowEx(ctx, "que_group_remaining", nil, groupID).Scan(&n)
+	return n, err
+}
+
+// PurgeByType deletes all jobs of type jobType in queue and returns how many
+// were deleted. Jobs that are currently locked by a worker are left alone.
+func (c *Client) PurgeByType(ctx context.Context, queue, jobType string) (int, error) {
+	return c.execMaintenance(ctx, "que_purge_by_type", queue, jobType, c.AdvisoryLockClass)
+}
+
+// DeleteOlderThan deletes all jobs in queue whose run_at is more than age in
+// the past and returns how many were deleted. It is meant for cleaning up
+// stale jobs, such as ones that keep failing or were inserted by hand. Jobs
+// that are currently locked by a worker are left alone. The cutoff is computed
+// with the database clock.
+func (c *Client) DeleteOlderThan(ctx context.Context, queue string, age time.Duration) (int, error) {
+	return c.execMaintenance(ctx, "que_delete_older_than", queue, age.Seconds(), c.AdvisoryLockClass)
+}
+
+// MoveQueue moves up to limit jobs from fromQueue to toQueue, for instance
+// to rebalance work between queues, and returns how many were moved. The jobs
+// that would be locked first are moved first. Jobs that are currently locked
+// by a worker are left alone, so no job is moved out from under its WorkFunc.
+// A limit of zero or less moves nothing.
+func (c *Client) MoveQueue(ctx context.Context, fromQueue, toQueue string, limit int) (int, error) {
+	if limit <= 0 || fromQueue == toQueue {
+		return 0, nil
+	}
+	return c.execMaintenance(ctx, "que_move_queue", fromQueue, toQueue, limit, c.AdvisoryLockClass)
+}
+
+// AgePriorities raises the priority of every job whose run_at is more than
+// olderThan in the past by step, down to the highest priority of 0, and
+// returns how many jobs were changed. Calling it periodically keeps
+// low-priority jobs from starving under a steady stream of high-priority
+// ones, since jobs keep gaining priority for as long as they wait. Jobs that
+// are currently locked by a worker are left alone. A step of zero or less
+// changes nothing.
+func (c *Client) AgePriorities(ctx context.Context, step int16, olderThan time.Duration) (int, error) {
+	if step <= 0 {
+		return 0, nil
+	}
+	return c.execMaintenance(ctx, "que_age_priorities", step, olderThan.Seconds(), c.AdvisoryLockClass)
+}
+
+// QueueStats is a snapshot of the jobs in a queue, as returned by
+// Client.QueueStats and AllQueueStats.
+type QueueStats struct {
+	// Count is the number of jobs in the queue, including the ones being
+	// worked and the ones scheduled for later.
+	Count int
+
+	// Ready is the number of jobs whose run_at has passed, including the ones
+	// being worked.
+	Ready int
+
+	// Working is the number of jobs locked by a worker, using the Client's
+	// AdvisoryLockClass.
+	Working int
+
+	// Errored is the number of jobs that failed at least once.
+	Errored int
+
+	// OldestRunAt is the earliest run_at in the queue.
+	OldestRunAt time.Time
+}
+
+// QueueStats returns the QueueStats of queue, which are all zero if it has no
+// jobs.
+func (c *Client) QueueStats(ctx context.Context, queue string) (QueueStats, error) {
+	var s QueueStats
+	var oldest pgtype.Timestamptz
+	err := c.pool.QueryRowEx(ctx, "que_one_queue_stats", nil, c.AdvisoryLockClass, queue).Scan(
+		&s.Count, &s.Ready, &s.Working, &s.Errored, &oldest)
+	if err != nil {
+		return QueueStats{}, err
+	}
+	if oldest.Status == pgtype.Present {
+		s.OldestRunAt = oldest.Time
+	}
+	return s, nil
+}
+
+// AllQueueStats returns a QueueStats for every queue that has jobs, keyed by
+// queue name, in a single query. Queues without jobs are missing from the
+// map.
+func (c *Client) AllQueueStats(ctx context.Context) (map[string]QueueStats, error) {
+	rows, err := c.pool.QueryEx(ctx, "que_queue_stats", nil, c.AdvisoryLockClass)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]QueueStats)
+	for rows.Next() {
+		var queue string
+		var s QueueStats
+		if err := rows.Scan(&queue, &s.Count, &s.Ready, &s.Working, &s.Errored, &s.OldestRunAt); err != nil {
+			return nil, err
+		}
+		stats[queue] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// DeleteExpired deletes the jobs of all queues whose ExpiresAt has passed and
+// returns how many were deleted, calling the Client's OnJobExpired with each
+// of them. LockJob already skips such jobs, so this only keeps them from
+// piling up; run it periodically. Jobs that are currently locked by a worker
+// are left alone.
+func (c *Client) DeleteExpired(ctx context.Context) (int, error) {
+	tx, err := c.pool.BeginEx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryEx(ctx, "que_delete_expired", nil, c.AdvisoryLockClass)
+	if err != nil {
+		return 0, err
+	}
+	var expired []*Job
+	for rows.Next() {
+		j := &Job{}
+		if err := scanJob(rows, j); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		expired = append(expired, j)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if c.DryRun {
+		return len(expired), nil
+	}
+	if err := tx.CommitEx(ctx); err != nil {
+		return 0, err
+	}
+
+	if c.OnJobExpired != nil {
+		for _, j := range expired {
+			c.OnJobExpired(j)
+		}
+	}
+	return len(expired), nil
+}
+
+// PauseQueue stops jobs in queue from being locked until ResumeQueue is
+// called, without stopping the workers: LockJob finds no jobs in a paused
+// queue, so its workers idle. Jobs that are already being worked are not
+// affected, and jobs can still be enqueued. Pausing a paused queue is a no-op.
+func (c *Client) PauseQueue(ctx context.Context, queue string) error {
+	_, err := c.pool.ExecEx(ctx, "que_pause_queue", nil, queue)
+	return err
+}
+
+// ResumeQueue lets the jobs of a queue paused with PauseQueue be locked again,
+// which workers pick up at their next poll. Resuming a queue that is not
+// paused is a no-op.
+func (c *Client) ResumeQueue(ctx context.Context, queue string) error {
+	_, err := c.pool.ExecEx(ctx, "que_resume_queue", nil, queue)
+	return err
+}
+
+// execMaintenance runs the maintenance statement stmt and returns the number
+// of jobs it changed. With DryRun the statement runs in a transaction that is
+// rolled back.
+func (c *Client) execMaintenance(ctx context.Context, stmt string, args ...interface{}) (int, error) {
+	if !c.DryRun {
+		ct, err := c.pool.ExecEx(ctx, stmt, nil, args...)
+		if err != nil {
+			return 0, err
+		}
+		return int(ct.RowsAffected()), nil
+	}
+
+	tx, err := c.pool.BeginEx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	ct, err := tx.ExecEx(ctx, stmt, nil, args...)
+	if err != nil {
+		return 0, err
+	}
+	return int(ct.RowsAffected()), nil
+}
@@ -0,0 +1,884 @@
+package que
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPeekNext(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	j, err := c.PeekNext(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j != nil {
+		t.Fatalf("want no job, got %+v", j)
+	}
+
+	if err = c.Enqueue(&Job{Type: "Later", RunAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Enqueue(&Job{Type: "Low", Priority: 200}); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Enqueue(&Job{Type: "High", Priority: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err = c.PeekNext(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	if want := "High"; j.Type != want {
+		t.Errorf("want Type=%q, got %q", want, j.Type)
+	}
+	if j.conn != nil {
+		t.Error("want peeked job to hold no conn")
+	}
+
+	// peeking must not lock the job
+	locked, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked == nil {
+		t.Fatal("wanted job, got none")
+	}
+	defer locked.Done()
+	if locked.ID != j.ID {
+		t.Errorf("want locked job %d, got %d", j.ID, locked.ID)
+	}
+}
+
+func TestPeekNextPausedAndDependencies(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	first := &Job{Type: "First", Priority: 100}
+	if err := c.Enqueue(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Enqueue(&Job{Type: "Second", Priority: 1, DependsOn: []int64{first.ID}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the job waiting for its dependency is not next in line
+	j, err := c.PeekNext(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil || j.Type != "First" {
+		t.Fatalf("want job First, got %+v", j)
+	}
+
+	if err = c.PauseQueue(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if j, err = c.PeekNext(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if j != nil {
+		t.Errorf("want no job of a paused queue, got %+v", j)
+	}
+}
+
+func TestWaitForJob(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	j := &Job{Type: "MyJob"}
+	if err := c.Enqueue(j); err != nil {
+		t.Fatal(err)
+	}
+
+	// the job is still there, so waiting must time out
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.WaitForJob(ctx, j.ID, 10*time.Millisecond); err != context.DeadlineExceeded {
+		t.Errorf("want DeadlineExceeded, got %v", err)
+	}
+
+	worked := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		locked, err := c.LockJob("")
+		if err == nil && locked == nil {
+			err = errors.New("wanted job, got none")
+		}
+		if err == nil {
+			err = locked.Delete()
+			locked.Done()
+		}
+		worked <- err
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.WaitForJob(ctx, j.ID, 10*time.Millisecond); err != nil {
+		t.Errorf("want nil once the job was worked, got %v", err)
+	}
+	if err := <-worked; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForceUnlock(t *testing.T) {
+	c := openTestClientMaxConns(t, 1)
+	defer truncateAndClose(c.pool)
+
+	j := &Job{Type: "MyJob"}
+	if err := c.Enqueue(j); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ForceUnlock(context.Background(), j.ID); err != nil {
+		t.Errorf("want nil for a job that is not locked, got %v", err)
+	}
+
+	// leak the lock on the pool's only connection
+	conn, err := c.pool.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Exec("SELECT pg_advisory_lock($1)", j.ID); err != nil {
+		t.Fatal(err)
+	}
+	c.pool.Release(conn)
+
+	other := openTestClient(t)
+	defer other.pool.Close()
+	if locked, err := other.LockJob(""); err != nil || locked != nil {
+		t.Fatalf("want the leaked lock to block the job, got %+v (err %v)", locked, err)
+	}
+	if err = other.ForceUnlock(context.Background(), j.ID); !errors.Is(err, ErrLockHeldElsewhere) {
+		t.Errorf("want ErrLockHeldElsewhere from another pool, got %v", err)
+	}
+
+	if err = c.ForceUnlock(context.Background(), j.ID); err != nil {
+		t.Fatal(err)
+	}
+	locked, err := other.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked == nil {
+		t.Fatal("want the job lockable after ForceUnlock, got none")
+	}
+	locked.Done()
+}
+
+func TestCountAndPurgeByType(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	for i := 0; i < 3; i++ {
+		if err := c.Enqueue(&Job{Type: "Obsolete", Priority: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Enqueue(&Job{Type: "Current", Priority: 200}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.CountByType(context.Background(), "", "Obsolete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("want 3 Obsolete jobs, got %d", n)
+	}
+
+	// a job being worked must survive the purge
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil || j.Type != "Obsolete" {
+		t.Fatalf("wanted Obsolete job, got %+v", j)
+	}
+	defer j.Done()
+
+	n, err = c.PurgeByType(context.Background(), "", "Obsolete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 2; n != want {
+		t.Errorf("want %d purged, got %d", want, n)
+	}
+
+	n, err = c.CountByType(context.Background(), "", "Obsolete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 1; n != want {
+		t.Errorf("want the locked Obsolete job to remain, got %d", n)
+	}
+	if n, err = c.CountByType(context.Background(), "", "Current"); err != nil || n != 1 {
+		t.Errorf("want 1 Current job, got %d (err %v)", n, err)
+	}
+}
+
+func TestAdvisoryLockCount(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	for i := 0; i < 2; i++ {
+		if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := c.AdvisoryLockCount(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("want no locks, got %d", n)
+	}
+
+	jobs, err := c.LockJobs(context.Background(), "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err = c.AdvisoryLockCount(context.Background()); err != nil || n != 2 {
+		t.Errorf("want 2 locks, got %d, %v", n, err)
+	}
+
+	DoneAll(jobs)
+	if n, err = c.AdvisoryLockCount(context.Background()); err != nil || n != 0 {
+		t.Errorf("want no locks after Done, got %d, %v", n, err)
+	}
+}
+
+func TestListJobs(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	var jobs []*Job
+	for _, j := range []*Job{
+		{Type: "MyJob"},
+		{Type: "MyJob"},
+		{Type: "OtherJob"},
+		{Type: "MyJob", Queue: "other"},
+	} {
+		if err := c.Enqueue(j); err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, j)
+	}
+	if _, err := c.pool.Exec("UPDATE que_jobs SET error_count = 2 WHERE job_id = $1", jobs[1].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(ds []*JobDetails) []int64 {
+		var ids []int64
+		for _, d := range ds {
+			ids = append(ids, d.ID)
+		}
+		return ids
+	}
+	tests := []struct {
+		filter JobFilter
+		limit  int
+		offset int
+		want   []int64
+		total  int
+	}{
+		{JobFilter{}, 0, 0, []int64{jobs[0].ID, jobs[1].ID, jobs[2].ID}, 3},
+		{JobFilter{AllQueues: true}, 2, 1, []int64{jobs[1].ID, jobs[2].ID}, 4},
+		{JobFilter{AllQueues: true, Type: "MyJob"}, 0, 0, []int64{jobs[0].ID, jobs[1].ID, jobs[3].ID}, 3},
+		{JobFilter{Failing: true}, 0, 0, []int64{jobs[1].ID}, 1},
+		{JobFilter{MinErrorCount: 1, MaxErrorCount: 1}, 0, 0, nil, 0},
+		{JobFilter{Queue: "other"}, 10, 5, nil, 1},
+	}
+	for _, tt := range tests {
+		ds, total, err := c.ListJobs(context.Background(), tt.filter, tt.limit, tt.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(ds); !reflect.DeepEqual(got, tt.want) || total != tt.total {
+			t.Errorf("%+v limit %d offset %d: want %v of %d, got %v of %d", tt.filter, tt.limit, tt.offset, tt.want, tt.total, got, total)
+		}
+	}
+}
+
+func TestSetPriority(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	pending := &Job{Type: "MyJob"}
+	locked := &Job{Type: "MyJob", Priority: 1}
+	for _, j := range []*Job{pending, locked} {
+		if err := c.Enqueue(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil || j.ID != locked.ID {
+		t.Fatalf("want job %d locked, got %+v", locked.ID, j)
+	}
+	defer j.Done()
+
+	if err := c.SetPriority(context.Background(), pending.ID, 5); err != nil {
+		t.Fatal(err)
+	}
+	d, err := c.GetJob(context.Background(), pending.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Priority != 5 {
+		t.Errorf("want priority 5, got %d", d.Priority)
+	}
+
+	if err := c.SetPriority(context.Background(), locked.ID, 5); err != ErrJobLocked {
+		t.Errorf("want ErrJobLocked, got %v", err)
+	}
+	if err := c.SetPriority(context.Background(), pending.ID, -1); !errors.Is(err, ErrInvalidPriority) {
+		t.Errorf("want ErrInvalidPriority, got %v", err)
+	}
+}
+
+func TestScheduledWithin(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	now := time.Now()
+	for _, j := range []*Job{
+		{Type: "Ready", RunAt: now.Add(-time.Minute)},
+		{Type: "Soon", RunAt: now.Add(10 * time.Minute)},
+		{Type: "Soon", RunAt: now.Add(50 * time.Minute)},
+		{Type: "Later", RunAt: now.Add(2 * time.Hour)},
+		{Type: "OtherQueue", Queue: "other", RunAt: now.Add(10 * time.Minute)},
+	} {
+		if err := c.Enqueue(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := c.ScheduledWithin(context.Background(), "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("want 2 jobs scheduled within an hour, got %d", n)
+	}
+}
+
+func TestGroupRemaining(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	var done []string
+	c.OnGroupDone = func(groupID string) {
+		done = append(done, groupID)
+	}
+
+	for _, j := range []*Job{
+		{Type: "Part", GroupID: "import-1"},
+		{Type: "Part", GroupID: "import-1"},
+		{Type: "Part", GroupID: "import-2"},
+		{Type: "Other"},
+	} {
+		if err := c.Enqueue(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := c.GroupRemaining(context.Background(), "import-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("want 2 jobs remaining, got %d", n)
+	}
+
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil || j.GroupID != "import-1" {
+		t.Fatalf("want a job of group import-1, got %+v", j)
+	}
+	if err := j.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	j.Done()
+	if len(done) != 0 {
+		t.Errorf("want no group done while a job is left, got %v", done)
+	}
+
+	w := NewWorker(c, WorkMap{"Part": nilWorker, "Other": nilWorker})
+	for w.WorkOne() {
+	}
+	if !reflect.DeepEqual(done, []string{"import-1", "import-2"}) {
+		t.Errorf("want both groups done once, got %v", done)
+	}
+	if n, err = c.GroupRemaining(context.Background(), "import-1"); err != nil || n != 0 {
+		t.Errorf("want no jobs remaining, got %d, %v", n, err)
+	}
+}
+
+func TestDeleteOlderThan(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	old := time.Now().Add(-48 * time.Hour)
+	for i := 0; i < 3; i++ {
+		if err := c.Enqueue(&Job{Type: "Stale", Priority: 1, RunAt: old}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Enqueue(&Job{Type: "Stale", Queue: "other", RunAt: old}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Enqueue(&Job{Type: "Fresh", Priority: 200}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a job being worked must survive the cleanup
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil || j.Type != "Stale" {
+		t.Fatalf("wanted Stale job, got %+v", j)
+	}
+	defer j.Done()
+
+	n, err := c.DeleteOlderThan(context.Background(), "", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 2; n != want {
+		t.Errorf("want %d deleted, got %d", want, n)
+	}
+
+	if n, err = c.CountByType(context.Background(), "", "Stale"); err != nil || n != 1 {
+		t.Errorf("want the locked Stale job to remain, got %d (err %v)", n, err)
+	}
+	if n, err = c.CountByType(context.Background(), "other", "Stale"); err != nil || n != 1 {
+		t.Errorf("want the Stale job in another queue to remain, got %d (err %v)", n, err)
+	}
+	if n, err = c.CountByType(context.Background(), "", "Fresh"); err != nil || n != 1 {
+		t.Errorf("want 1 Fresh job, got %d (err %v)", n, err)
+	}
+}
+
+func TestMoveQueue(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	for i := 0; i < 4; i++ {
+		if err := c.Enqueue(&Job{Type: "MyJob", Queue: "busy", Priority: int16(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a job being worked must not be moved
+	j, err := c.LockJob("busy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	defer j.Done()
+
+	n, err := c.MoveQueue(context.Background(), "busy", "idle", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 2; n != want {
+		t.Errorf("want %d moved, got %d", want, n)
+	}
+
+	var priorities []int16
+	rows, err := c.pool.Query("SELECT priority FROM que_jobs WHERE queue = 'idle' ORDER BY priority")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var p int16
+		if err = rows.Scan(&p); err != nil {
+			t.Fatal(err)
+		}
+		priorities = append(priorities, p)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int16{1, 2}; !reflect.DeepEqual(priorities, want) {
+		t.Errorf("want the next jobs in line moved, with priorities %v, got %v", want, priorities)
+	}
+
+	n, err = c.MoveQueue(context.Background(), "busy", "idle", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 1; n != want {
+		t.Errorf("want %d moved, leaving the locked job, got %d", want, n)
+	}
+}
+
+func TestMoveQueueLocksOnlyLimit(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	for i := 0; i < 4; i++ {
+		if err := c.Enqueue(&Job{Type: "MyJob", Queue: "busy"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx, err := c.pool.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec("que_move_queue", "busy", "idle", 1, c.AdvisoryLockClass); err != nil {
+		t.Fatal(err)
+	}
+
+	// the other jobs of the queue must stay lockable by workers
+	var locks int
+	err = tx.QueryRow("SELECT count(*) FROM pg_locks WHERE locktype = 'advisory' AND pid = pg_backend_pid()").Scan(&locks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locks != 1 {
+		t.Errorf("want only the moved job locked, got %d advisory locks", locks)
+	}
+}
+
+func TestAgePriorities(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	old := time.Now().Add(-time.Hour)
+	for _, j := range []*Job{
+		{Type: "Old", Priority: 100, RunAt: old},
+		{Type: "OldHigh", Priority: 3, RunAt: old},
+		{Type: "Locked", Priority: 0, RunAt: old.Add(-time.Hour)},
+		{Type: "New", Priority: 100},
+	} {
+		if err := c.Enqueue(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a job being worked must not be changed
+	locked, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked == nil || locked.Type != "Locked" {
+		t.Fatalf("wanted Locked job, got %+v", locked)
+	}
+	defer locked.Done()
+	// it was locked first thanks to priority 0, now give it room to age
+	if _, err = c.pool.Exec("UPDATE que_jobs SET priority = 50 WHERE job_id = $1", locked.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.AgePriorities(context.Background(), 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 2; n != want {
+		t.Errorf("want %d aged, got %d", want, n)
+	}
+
+	want := map[string]int16{"Old": 90, "OldHigh": 0, "Locked": 50, "New": 100}
+	for jobType, priority := range want {
+		var got int16
+		if err = c.pool.QueryRow("SELECT priority FROM que_jobs WHERE job_class = $1", jobType).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != priority {
+			t.Errorf("want %s job at priority %d, got %d", jobType, priority, got)
+		}
+	}
+}
+
+func TestAllQueueStats(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	stats, err := c.AllQueueStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 0 {
+		t.Errorf("want no stats without jobs, got %+v", stats)
+	}
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+	for _, j := range []*Job{
+		{Type: "MyJob", Queue: "a", RunAt: old},
+		{Type: "MyJob", Queue: "a"},
+		{Type: "MyJob", Queue: "a", RunAt: time.Now().Add(time.Hour)},
+		{Type: "MyJob", Queue: "b"},
+	} {
+		if err = c.Enqueue(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	j, err := c.LockJob("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	defer j.Done()
+
+	j2, err := c.LockJob("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j2 == nil {
+		t.Fatal("wanted job, got none")
+	}
+	if err = j2.Error("oops"); err != nil {
+		t.Fatal(err)
+	}
+	j2.Done()
+
+	stats, err = c.AllQueueStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("want stats for 2 queues, got %+v", stats)
+	}
+	a := stats["a"]
+	if a.Count != 3 || a.Ready != 1 || a.Working != 1 || a.Errored != 1 {
+		t.Errorf("want Count=3 Ready=1 Working=1 Errored=1 for queue a, got %+v", a)
+	}
+	if !a.OldestRunAt.Equal(old) {
+		t.Errorf("want OldestRunAt=%s for queue a, got %s", old, a.OldestRunAt)
+	}
+	if b := stats["b"]; b.Count != 1 || b.Ready != 1 || b.Working != 0 || b.Errored != 0 {
+		t.Errorf("want Count=1 Ready=1 for queue b, got %+v", b)
+	}
+
+	one, err := c.QueueStats(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if one != a {
+		t.Errorf("want QueueStats of queue a to be %+v, got %+v", a, one)
+	}
+	if one, err = c.QueueStats(context.Background(), "empty"); err != nil {
+		t.Fatal(err)
+	}
+	if one != (QueueStats{}) {
+		t.Errorf("want zero QueueStats for a queue without jobs, got %+v", one)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	old := time.Now().Add(-48 * time.Hour)
+	for i := 0; i < 3; i++ {
+		if err := c.Enqueue(&Job{Type: "Obsolete", Priority: 10, RunAt: old}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dry := *c
+	dry.DryRun = true
+	ctx := context.Background()
+	for name, run := range map[string]func() (int, error){
+		"PurgeByType":     func() (int, error) { return dry.PurgeByType(ctx, "", "Obsolete") },
+		"DeleteOlderThan": func() (int, error) { return dry.DeleteOlderThan(ctx, "", time.Hour) },
+		"MoveQueue":       func() (int, error) { return dry.MoveQueue(ctx, "", "other", 2) },
+		"AgePriorities":   func() (int, error) { return dry.AgePriorities(ctx, 1, time.Hour) },
+	} {
+		n, err := run()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		want := 3
+		if name == "MoveQueue" {
+			want = 2
+		}
+		if n != want {
+			t.Errorf("%s: want %d jobs reported, got %d", name, want, n)
+		}
+	}
+
+	var count int
+	if err := c.pool.QueryRow("SELECT count(*) FROM que_jobs WHERE queue = '' AND priority = 10").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("want all 3 jobs unchanged after dry runs, got %d", count)
+	}
+}
+
+func TestExpiresAt(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	var expired []*Job
+	c.OnJobExpired = func(j *Job) { expired = append(expired, j) }
+
+	stale := &Job{Type: "Stale", Priority: 1, ExpiresAt: time.Now().Add(-time.Minute)}
+	for _, j := range []*Job{
+		stale,
+		{Type: "Expiring", Priority: 2, ExpiresAt: time.Now().Add(time.Hour)},
+		{Type: "Forever", Priority: 3},
+	} {
+		if err := c.Enqueue(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil || j.Type != "Expiring" {
+		t.Fatalf("want the expired job skipped and Expiring locked, got %+v", j)
+	}
+	if j.ExpiresAt.IsZero() {
+		t.Error("want ExpiresAt set on the locked job")
+	}
+	defer j.Done()
+
+	n, err := c.DeleteExpired(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("want 1 expired job deleted, got %d", n)
+	}
+	if len(expired) != 1 || expired[0].ID != stale.ID {
+		t.Errorf("want OnJobExpired called with job %d, got %+v", stale.ID, expired)
+	}
+	if d, err := c.GetJob(context.Background(), stale.ID); err != nil || d != nil {
+		t.Errorf("want the expired job gone, got %+v (err %v)", d, err)
+	}
+}
+
+func TestPauseAndResumeQueue(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	for _, queue := range []string{"paused", "other"} {
+		if err := c.Enqueue(&Job{Type: "MyJob", Queue: queue}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		// pausing twice must not fail
+		if err := c.PauseQueue(context.Background(), "paused"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j, err := c.LockJob("paused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j != nil {
+		j.Done()
+		t.Fatalf("want no job from a paused queue, got %+v", j)
+	}
+
+	j, err = c.LockJob("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("want a job from the queue that was not paused, got none")
+	}
+	j.Done()
+
+	if err = c.ResumeQueue(context.Background(), "paused"); err != nil {
+		t.Fatal(err)
+	}
+	j, err = c.LockJob("paused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("want a job once the queue was resumed, got none")
+	}
+	j.Done()
+}
+
+func TestGetJob(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	d, err := c.GetJob(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != nil {
+		t.Fatalf("want no job, got %+v", d)
+	}
+
+	enqueued := &Job{Type: "MyJob", Queue: "details", Priority: 7, Args: []byte(`[1]`)}
+	if err = c.Enqueue(enqueued); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = c.GetJob(context.Background(), enqueued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d == nil {
+		t.Fatal("wanted job details, got none")
+	}
+	if d.ID != enqueued.ID || d.Queue != "details" || d.Priority != 7 || d.Type != "MyJob" {
+		t.Errorf("want details of the enqueued job, got %+v", d)
+	}
+	if want, got := "[1]", string(d.Args); got != want {
+		t.Errorf("want Args=%s, got %s", want, got)
+	}
+	if d.Locked {
+		t.Error("want Locked=false before the job is locked")
+	}
+
+	j, err := c.LockJob("details")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	defer j.Done()
+	if err = j.Error("failed"); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = c.GetJob(context.Background(), enqueued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Locked {
+		t.Error("want Locked=true while the job is locked")
+	}
+	if d.ErrorCount != 1 || d.LastError.String != "failed" {
+		t.Errorf("want the recorded error, got ErrorCount=%d LastError=%q", d.ErrorCount, d.LastError.String)
+	}
+	if !d.RunAt.Equal(j.RunAt) {
+		t.Errorf("want RunAt=%s, got %s", j.RunAt, d.RunAt)
+	}
+}
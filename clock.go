@@ -0,0 +1,10 @@
+package que
+
+import "time"
+
+// Clock tells the time. Setting a Client's Clock to a fake one makes the
+// times and durations that are computed in Go deterministic in tests, see
+// Client.Clock.
+type Clock interface {
+	Now() time.Time
+}
@@ -0,0 +1,74 @@
+package que
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// ArgsCodec converts between Go values and the Args of a Job. Marshal must
+// produce valid JSON, because Args are stored in a json column and may be
+// read by Ruby workers, but the encoding can differ from encoding/json, e.g.
+// protobuf JSON or a canonical form.
+type ArgsCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// jsonCodec is the default ArgsCodec.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+func codecOrDefault(codec ArgsCodec) ArgsCodec {
+	if codec == nil {
+		return jsonCodec{}
+	}
+	return codec
+}
+
+// EnqueueValue adds a job of type jobType to queue, with v encoded by the
+// Client's ArgsCodec as its Args.
+func (c *Client) EnqueueValue(queue, jobType string, v interface{}, opts ...EnqueueOption) error {
+	args, err := codecOrDefault(c.ArgsCodec).Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Enqueue(&Job{Queue: queue, Type: jobType, Args: args}, opts...)
+}
+
+// UnmarshalArgs decodes the job's Args into v with the ArgsCodec of the
+// Client that locked it, mirroring EnqueueValue. Jobs that were not locked
+// by a Client use encoding/json.
+func (j *Job) UnmarshalArgs(v interface{}) error {
+	return codecOrDefault(j.argsCodec).Unmarshal(j.Args, v)
+}
+
+// ArgsWorkFunc is a WorkFunc that receives the job's Args already decoded,
+// see WithArgs. ctx is the job's Context.
+type ArgsWorkFunc func(ctx context.Context, j *Job, args interface{}) error
+
+// WithArgs returns a WorkFunc that decodes the Args of each job with
+// UnmarshalArgs into a new value from newArgs, usually a pointer to the
+// type's args struct, and passes it to fn, which can type-assert it without
+// checking:
+//
+//	wm := que.WorkMap{
+//		"SendEmail": que.WithArgs(func() interface{} { return &EmailArgs{} },
+//			func(ctx context.Context, j *que.Job, args interface{}) error {
+//				return send(ctx, args.(*EmailArgs))
+//			}),
+//	}
+//
+// A job whose Args cannot be decoded fails with an error wrapping
+// ErrInvalidArgs without calling fn.
+func WithArgs(newArgs func() interface{}, fn ArgsWorkFunc) WorkFunc {
+	return func(j *Job) error {
+		args := newArgs()
+		if err := j.UnmarshalArgs(args); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArgs, err)
+		}
+		return fn(j.Context(), j, args)
+	}
+}
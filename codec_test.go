@@ -0,0 +1,169 @@
+package que
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/pgtype"
+)
+
+// envelopeCodec wraps values in an object, to tell its output apart from
+// plain encoding/json.
+type envelopeCodec struct{}
+
+func (envelopeCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{"value": v})
+}
+
+func (envelopeCodec) Unmarshal(data []byte, v interface{}) error {
+	var envelope struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return err
+	}
+	return json.Unmarshal(envelope.Value, v)
+}
+
+type codecArgs struct {
+	Name string
+}
+
+func TestEnqueueValue(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.EnqueueValue("", "MyJob", codecArgs{Name: "default"}); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	defer j.Done()
+
+	if want, got := `{"Name":"default"}`, string(j.Args); got != want {
+		t.Errorf("want Args=%s, got %s", want, got)
+	}
+	var args codecArgs
+	if err = j.UnmarshalArgs(&args); err != nil {
+		t.Fatal(err)
+	}
+	if args.Name != "default" {
+		t.Errorf("want Name=%q, got %q", "default", args.Name)
+	}
+}
+
+func TestEnqueueValueArgsCodec(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+	c.ArgsCodec = envelopeCodec{}
+
+	if err := c.EnqueueValue("custom", "MyJob", codecArgs{Name: "custom"}, WithPriority(5)); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := c.LockJob("custom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	defer j.Done()
+
+	if want, got := `{"value":{"Name":"custom"}}`, string(j.Args); got != want {
+		t.Errorf("want Args=%s, got %s", want, got)
+	}
+	if j.Priority != 5 {
+		t.Errorf("want Priority=5, got %d", j.Priority)
+	}
+	var args codecArgs
+	if err = j.UnmarshalArgs(&args); err != nil {
+		t.Fatal(err)
+	}
+	if args.Name != "custom" {
+		t.Errorf("want Name=%q, got %q", "custom", args.Name)
+	}
+}
+
+func TestWithArgs(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.EnqueueValue("", "MyJob", codecArgs{Name: "typed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Enqueue(&Job{Type: "MyJob", Args: []byte(`"not an object"`), Priority: 200}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	w := NewWorker(c, WorkMap{
+		"MyJob": WithArgs(func() interface{} { return &codecArgs{} },
+			func(ctx context.Context, j *Job, args interface{}) error {
+				got = append(got, args.(*codecArgs).Name)
+				return nil
+			}),
+	})
+
+	for i := 0; i < 2; i++ {
+		if !w.WorkOne() {
+			t.Fatal("want a job worked")
+		}
+	}
+	if len(got) != 1 || got[0] != "typed" {
+		t.Errorf("want the handler called once with Name=typed, got %q", got)
+	}
+
+	j, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil || !strings.Contains(j.LastError.String, ErrInvalidArgs.Error()) {
+		t.Errorf("want the job with undecodable args errored with ErrInvalidArgs, got %+v", j)
+	}
+}
+
+func TestJobMarshalJSON(t *testing.T) {
+	runAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	j := &Job{
+		ID:       7,
+		Queue:    "email",
+		Priority: 50,
+		RunAt:    runAt,
+		Type:     "SendEmail",
+		Args:     []byte(`{"to":"a@example.com"}`),
+		Tags:     map[string]string{"tenant": "acme"},
+	}
+
+	b, err := json.Marshal(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":7,"queue":"email","priority":50,"run_at":"2020-01-02T03:04:05Z","type":"SendEmail","args":{"to":"a@example.com"},"tags":{"tenant":"acme"},"error_count":0,"last_error":null}`
+	if string(b) != want {
+		t.Errorf("want %s, got %s", want, b)
+	}
+
+	j.Args = nil
+	j.ErrorCount = 1
+	j.LastError = pgtype.Text{String: "boom", Status: pgtype.Present}
+	if b, err = json.Marshal(j); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err = json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["last_error"] != "boom" || got["args"] != nil {
+		t.Errorf("want last_error=boom and args=null, got %s", b)
+	}
+}
@@ -0,0 +1,49 @@
+package que
+
+import (
+	"context"
+
+	"github.com/jackc/pgx"
+)
+
+// JobInfo describes the job being worked. It is attached to the context
+// returned by Job.Context, so code called from a WorkFunc can log or tag its
+// work without being handed the *Job.
+type JobInfo struct {
+	ID         int64
+	Queue      string
+	Type       string
+	ErrorCount int32
+}
+
+type jobInfoKey struct{}
+
+// FromContext returns the JobInfo attached to ctx by Job.Context, if any.
+func FromContext(ctx context.Context) (JobInfo, bool) {
+	info, ok := ctx.Value(jobInfoKey{}).(JobInfo)
+	return info, ok
+}
+
+// newJobContext returns a copy of ctx carrying the JobInfo of j.
+func newJobContext(ctx context.Context, j *Job) context.Context {
+	return context.WithValue(ctx, jobInfoKey{}, JobInfo{
+		ID:         j.ID,
+		Queue:      j.Queue,
+		Type:       j.Type,
+		ErrorCount: j.ErrorCount,
+	})
+}
+
+type txKey struct{}
+
+// WithTx returns a copy of ctx carrying tx, which Client.EnqueueContext then
+// enqueues jobs in. The same conditions as for EnqueueInTx apply to tx.
+func WithTx(ctx context.Context, tx *pgx.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// TxFromContext returns the transaction attached to ctx by WithTx, if any.
+func TxFromContext(ctx context.Context) (*pgx.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(*pgx.Tx)
+	return tx, ok && tx != nil
+}
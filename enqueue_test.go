@@ -1,17 +1,73 @@
 package que
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/jackc/pgx"
 	"github.com/jackc/pgx/pgtype"
 )
 
+func TestEnqueueError(t *testing.T) {
+	c := openTestClient(t)
+	c.pool.Close()
+
+	err := c.Enqueue(&Job{Type: "MyJob", Queue: "mail"})
+	var enqueueErr *EnqueueError
+	if !errors.As(err, &enqueueErr) {
+		t.Fatalf("want an EnqueueError, got %v", err)
+	}
+	if enqueueErr.Type != "MyJob" || enqueueErr.Queue != "mail" {
+		t.Errorf("want type MyJob and queue mail, got %+v", enqueueErr)
+	}
+	if !errors.Is(err, pgx.ErrClosedPool) {
+		t.Errorf("want the error to wrap ErrClosedPool, got %v", err)
+	}
+}
+
+func TestEnqueueWithAsyncCommit(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	j := &Job{Type: "Event"}
+	if err := c.Enqueue(j, WithAsyncCommit()); err != nil {
+		t.Fatal(err)
+	}
+	if j.ID == 0 {
+		t.Error("want the job's ID set")
+	}
+	if n := countJobs(t, c.pool); n != 1 {
+		t.Fatalf("want 1 job, got %d", n)
+	}
+
+	tx, err := c.pool.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := c.EnqueueInTx(&Job{Type: "Event"}, tx, WithAsyncCommit()); err != nil {
+		t.Fatal(err)
+	}
+	var setting string
+	if err := tx.QueryRow("SHOW synchronous_commit").Scan(&setting); err != nil {
+		t.Fatal(err)
+	}
+	if setting != "off" {
+		t.Errorf("want synchronous_commit off in the transaction, got %q", setting)
+	}
+}
+
 func TestEnqueueOnlyType(t *testing.T) {
 	c := openTestClient(t)
 	defer truncateAndClose(c.pool)
 
-	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+	enqueued := &Job{Type: "MyJob"}
+	if err := c.Enqueue(enqueued); err != nil {
 		t.Fatal(err)
 	}
 
@@ -24,6 +80,9 @@ func TestEnqueueOnlyType(t *testing.T) {
 	if j.ID == 0 {
 		t.Errorf("want non-zero ID")
 	}
+	if enqueued.ID != j.ID {
+		t.Errorf("want Enqueue to set ID=%d, got %d", j.ID, enqueued.ID)
+	}
 	if want := ""; j.Queue != want {
 		t.Errorf("want Queue=%q, got %q", want, j.Queue)
 	}
@@ -66,6 +125,43 @@ func TestEnqueueWithPriority(t *testing.T) {
 	}
 }
 
+func TestEnqueueWithPriorityZero(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}, WithPriority(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int16(0); j.Priority != want {
+		t.Errorf("want Priority=%d, got %d", want, j.Priority)
+	}
+}
+
+func TestEnqueueWithNegativePriority(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob", Priority: -1}); !errors.Is(err, ErrInvalidPriority) {
+		t.Errorf("want ErrInvalidPriority, got %v", err)
+	}
+	if err := c.Enqueue(&Job{Type: "MyJob"}, WithPriority(-5)); !errors.Is(err, ErrInvalidPriority) {
+		t.Errorf("want ErrInvalidPriority from WithPriority, got %v", err)
+	}
+
+	j, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j != nil {
+		t.Errorf("want no job enqueued, got %+v", j)
+	}
+}
+
 func TestEnqueueWithRunAt(t *testing.T) {
 	c := openTestClient(t)
 	defer truncateAndClose(c.pool)
@@ -87,6 +183,31 @@ func TestEnqueueWithRunAt(t *testing.T) {
 	}
 }
 
+func TestEnqueueNow(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.EnqueueNow(&Job{Type: "UrgentJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	defer j.Done()
+
+	if want := "UrgentJob"; j.Type != want {
+		t.Errorf("want Type=%q, got %q", want, j.Type)
+	}
+}
+
 func TestEnqueueWithArgs(t *testing.T) {
 	c := openTestClient(t)
 	defer truncateAndClose(c.pool)
@@ -106,6 +227,47 @@ func TestEnqueueWithArgs(t *testing.T) {
 	}
 }
 
+func TestEnqueueMaxArgsSize(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+	c.MaxArgsSize = 16
+
+	big := []byte(`["0123456789abcdef"]`)
+	if err := c.Enqueue(&Job{Type: "MyJob", Args: big}); !errors.Is(err, ErrArgsTooLarge) {
+		t.Errorf("want ErrArgsTooLarge, got %v", err)
+	}
+	j, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j != nil {
+		t.Fatalf("want no job enqueued, got %+v", j)
+	}
+
+	var offloaded []byte
+	c.OffloadArgs = func(j *Job) ([]byte, error) {
+		offloaded = j.Args
+		return []byte(`["blob:1"]`), nil
+	}
+	if err = c.Enqueue(&Job{Type: "MyJob", Args: big}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(offloaded, big) {
+		t.Errorf("want OffloadArgs called with %s, got %s", big, offloaded)
+	}
+	if j, err = findOneJob(c.pool); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte(`["blob:1"]`); j == nil || !bytes.Equal(j.Args, want) {
+		t.Errorf("want the reference %s stored as args, got %+v", want, j)
+	}
+
+	c.OffloadArgs = func(j *Job) ([]byte, error) { return j.Args, nil }
+	if err = c.Enqueue(&Job{Type: "MyJob", Args: big}); !errors.Is(err, ErrArgsTooLarge) {
+		t.Errorf("want ErrArgsTooLarge for a reference that is still too large, got %v", err)
+	}
+}
+
 func TestEnqueueWithQueue(t *testing.T) {
 	c := openTestClient(t)
 	defer truncateAndClose(c.pool)
@@ -125,6 +287,88 @@ func TestEnqueueWithQueue(t *testing.T) {
 	}
 }
 
+func TestEnqueueDefaultQueue(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+	c.DefaultQueue = "default"
+
+	for _, tt := range []struct {
+		job  *Job
+		opts []EnqueueOption
+		want string
+	}{
+		{&Job{Type: "Default"}, nil, "default"},
+		{&Job{Type: "Named", Queue: "named"}, nil, "named"},
+		{&Job{Type: "Option"}, []EnqueueOption{WithQueue("option")}, "option"},
+		{&Job{Type: "Nameless"}, []EnqueueOption{WithQueue("")}, ""},
+	} {
+		if err := c.Enqueue(tt.job, tt.opts...); err != nil {
+			t.Fatal(err)
+		}
+		var queue string
+		err := c.pool.QueryRow("SELECT queue FROM que_jobs WHERE job_class = $1", tt.job.Type).Scan(&queue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if queue != tt.want {
+			t.Errorf("%s: want Queue=%q, got %q", tt.job.Type, tt.want, queue)
+		}
+	}
+}
+
+func TestEnqueueRegisteredType(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+	c.DefaultQueue = "default"
+	c.RegisterType("SendEmail", TypeOptions{Queue: "email", Priority: 50})
+	c.RegisterType("QueueOnly", TypeOptions{Queue: "only"})
+
+	for i, tt := range []struct {
+		job          *Job
+		opts         []EnqueueOption
+		wantQueue    string
+		wantPriority int16
+	}{
+		{&Job{Type: "SendEmail"}, nil, "email", 50},
+		{&Job{Type: "SendEmail", Queue: "urgent", Priority: 1}, nil, "urgent", 1},
+		{&Job{Type: "SendEmail"}, []EnqueueOption{WithQueue("option"), WithPriority(0)}, "option", 0},
+		{&Job{Type: "QueueOnly"}, nil, "only", 100},
+		{&Job{Type: "Unregistered"}, nil, "default", 100},
+	} {
+		if err := c.Enqueue(tt.job, tt.opts...); err != nil {
+			t.Fatal(err)
+		}
+		var queue string
+		var priority int16
+		err := c.pool.QueryRow("SELECT queue, priority FROM que_jobs WHERE job_id = $1", tt.job.ID).Scan(&queue, &priority)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if queue != tt.wantQueue || priority != tt.wantPriority {
+			t.Errorf("%d: want Queue=%q Priority=%d, got %q %d", i, tt.wantQueue, tt.wantPriority, queue, priority)
+		}
+	}
+}
+
+func TestEnqueueWithTags(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	want := map[string]string{"tenant_id": "42", "source": "api"}
+	if err := c.Enqueue(&Job{Type: "MyJob", Tags: want}); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(j.Tags, want) {
+		t.Errorf("want Tags=%v, got %v", want, j.Tags)
+	}
+}
+
 func TestEnqueueWithEmptyType(t *testing.T) {
 	c := openTestClient(t)
 	defer truncateAndClose(c.pool)
@@ -168,3 +412,471 @@ func TestEnqueueInTx(t *testing.T) {
 		t.Fatalf("wanted job to be rolled back, got %+v", j)
 	}
 }
+
+func TestEnqueueContextWithTx(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	tx, err := c.pool.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	ctx := WithTx(context.Background(), tx)
+	if err = c.EnqueueContext(ctx, &Job{Type: "InTx"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.EnqueueContext(context.Background(), &Job{Type: "NoTx"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the job in the transaction is not visible outside it yet
+	if n, err := c.CountByType(context.Background(), "", "InTx"); err != nil || n != 0 {
+		t.Errorf("want the job uncommitted, got %d (err %v)", n, err)
+	}
+	if n, err := c.CountByType(context.Background(), "", "NoTx"); err != nil || n != 1 {
+		t.Errorf("want the job without a transaction committed, got %d (err %v)", n, err)
+	}
+
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.CountByType(context.Background(), "", "InTx"); err != nil || n != 0 {
+		t.Errorf("want the job rolled back with the transaction, got %d (err %v)", n, err)
+	}
+}
+
+func TestEnqueueWithOptions(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	runAt := time.Now().Add(time.Hour)
+	opts := []EnqueueOption{WithQueue("low"), WithPriority(300), WithRunAt(runAt)}
+	orig := &Job{Type: "MyJob", Queue: "high", Priority: 10}
+	if err := c.Enqueue(orig, append(opts, WithPriority(200))...); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "low"; j.Queue != want {
+		t.Errorf("want Queue=%q, got %q", want, j.Queue)
+	}
+	if want := int16(200); j.Priority != want {
+		t.Errorf("want the last WithPriority to win with Priority=%d, got %d", want, j.Priority)
+	}
+	if !j.RunAt.Equal(runAt.Truncate(time.Microsecond)) {
+		t.Errorf("want RunAt=%s, got %s", runAt, j.RunAt)
+	}
+
+	if orig.Queue != "high" || orig.Priority != 10 || !orig.RunAt.IsZero() {
+		t.Errorf("want options to leave the job unmodified, got %+v", orig)
+	}
+}
+
+func TestEnqueueInTxWithOptions(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	tx, err := c.pool.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if err = c.EnqueueInTx(&Job{Type: "MyJob"}, tx, WithPriority(10)); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := findOneJob(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("want job, got none")
+	}
+	if want := int16(10); j.Priority != want {
+		t.Errorf("want Priority=%d, got %d", want, j.Priority)
+	}
+}
+
+func TestEnqueueNotify(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+	c.NotifyChannel = "que_jobs_test"
+
+	conn, err := c.pool.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.pool.Release(conn)
+	if err = conn.Listen(c.NotifyChannel); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Unlisten(c.NotifyChannel)
+
+	if err = c.Enqueue(&Job{Type: "MyJob", Queue: "notified"}); err != nil {
+		t.Fatal(err)
+	}
+	j, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	notification, err := conn.WaitForNotification(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := ParseJobNotification(notification.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (JobNotification{Queue: "notified", ID: j.ID}); *n != want {
+		t.Errorf("want notification %+v, got %+v", want, *n)
+	}
+}
+
+func TestParseJobNotification(t *testing.T) {
+	n, err := ParseJobNotification(`{"queue":"q","id":123}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (JobNotification{Queue: "q", ID: 123}); *n != want {
+		t.Errorf("want %+v, got %+v", want, *n)
+	}
+
+	if _, err = ParseJobNotification("not json"); err == nil {
+		t.Error("want error for an invalid payload")
+	}
+}
+
+func TestEnqueueInvalidDependency(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	first := &Job{Type: "First"}
+	if err := c.Enqueue(first); err != nil {
+		t.Fatal(err)
+	}
+	err := c.Enqueue(&Job{Type: "Second", DependsOn: []int64{first.ID + 1000}})
+	if !errors.Is(err, ErrInvalidDependency) {
+		t.Errorf("want ErrInvalidDependency, got %v", err)
+	}
+}
+
+func TestEnqueueIdempotencyKey(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	first := &Job{Type: "Webhook", Args: []byte(`{"request":1}`), IdempotencyKey: "event-42"}
+	if err := c.Enqueue(first); err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == 0 {
+		t.Fatal("want the first job to be created")
+	}
+
+	dup := &Job{Type: "Webhook", Args: []byte(`{"request":2}`), IdempotencyKey: "event-42"}
+	if err := c.Enqueue(dup); err != nil {
+		t.Fatal(err)
+	}
+	if dup.ID != 0 {
+		t.Errorf("want the duplicate not to be created, got ID=%d", dup.ID)
+	}
+	if n, err := c.CountByType(context.Background(), "", "Webhook"); err != nil || n != 1 {
+		t.Errorf("want 1 Webhook job, got %d (err %v)", n, err)
+	}
+
+	// jobs without a key are never deduplicated
+	for i := 0; i < 2; i++ {
+		if err := c.Enqueue(&Job{Type: "Plain"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := c.CountByType(context.Background(), "", "Plain"); err != nil || n != 2 {
+		t.Errorf("want 2 Plain jobs, got %d (err %v)", n, err)
+	}
+
+	// the key is free again once the job is gone
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil || j.ID != first.ID {
+		t.Fatalf("want the first job, got %+v", j)
+	}
+	if err = j.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	j.Done()
+	if err = c.Enqueue(dup); err != nil {
+		t.Fatal(err)
+	}
+	if dup.ID == 0 {
+		t.Error("want the key to be reusable after the job was deleted")
+	}
+}
+
+func TestEnqueueRequireRegisteredTypes(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	c.RequireRegisteredTypes = true
+	if err := c.Enqueue(&Job{Type: "Unregistered"}); err != nil {
+		t.Fatalf("want any type allowed while none are registered, got %v", err)
+	}
+
+	c.RegisterType("MyJob", TypeOptions{})
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+	err := c.Enqueue(&Job{Type: "MyJbo"})
+	if !errors.Is(err, ErrUnregisteredType) {
+		t.Fatalf("want ErrUnregisteredType, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `"MyJbo"`) {
+		t.Errorf("want the type in the error, got %q", err)
+	}
+	if n := countJobs(t, c.pool); n != 2 {
+		t.Errorf("want 2 jobs, got %d", n)
+	}
+}
+
+func TestEnqueueOtherUniqueViolation(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if _, err := c.pool.Exec("DROP INDEX IF EXISTS que_test_unique_idx"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.pool.Exec("CREATE UNIQUE INDEX que_test_unique_idx ON que_jobs (job_class) WHERE job_class = 'Unique'"); err != nil {
+		t.Fatal(err)
+	}
+	defer c.pool.Exec("DROP INDEX que_test_unique_idx")
+
+	if err := c.Enqueue(&Job{Type: "Unique"}); err != nil {
+		t.Fatal(err)
+	}
+	// only the idempotency_key is deduplicated silently
+	var pgErr pgx.PgError
+	if err := c.Enqueue(&Job{Type: "Unique"}); !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		t.Errorf("want a unique violation, got %v", err)
+	}
+}
+
+func TestEnqueueDebounce(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		j := &Job{Type: "Sync", DebounceKey: "user-7", DebounceWindow: time.Hour}
+		if err := c.Enqueue(j); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, j.ID)
+	}
+	if ids[0] == 0 || ids[1] != 0 || ids[2] != 0 {
+		t.Fatalf("want only the first job created, got IDs %v", ids)
+	}
+	d, err := c.GetJob(context.Background(), ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.RunAt.Before(time.Now().Add(59 * time.Minute)) {
+		t.Errorf("want the job to run at the end of the window, got %s", d.RunAt)
+	}
+
+	other := &Job{Type: "Sync", DebounceKey: "user-8", DebounceWindow: time.Hour}
+	if err := c.Enqueue(other); err != nil {
+		t.Fatal(err)
+	}
+	if other.ID == 0 {
+		t.Error("want a job with another key to be created")
+	}
+
+	// once the pending job is due, the key starts a new window
+	if _, err := c.pool.Exec("UPDATE que_jobs SET run_at = now() - interval '1 second' WHERE job_id = $1", ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	next := &Job{Type: "Sync", DebounceKey: "user-7", DebounceWindow: time.Hour}
+	if err := c.Enqueue(next); err != nil {
+		t.Fatal(err)
+	}
+	if next.ID == 0 {
+		t.Error("want a job to be created after the window")
+	}
+}
+
+func TestEnqueueCopy(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+	c.RegisterType("Mail", TypeOptions{Queue: "mail", Priority: 10})
+
+	runAt := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	jobs := []*Job{
+		{Type: "Mail"},
+		{Type: "MyJob", Args: []byte(`{"n":1}`), Tags: map[string]string{"tenant": "a"}, RunAt: runAt},
+	}
+	n, err := c.EnqueueCopy(context.Background(), jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("want 2 jobs copied, got %d", n)
+	}
+
+	var queue string
+	var priority int16
+	if err := c.pool.QueryRow("SELECT queue, priority FROM que_jobs WHERE job_class = 'Mail'").Scan(&queue, &priority); err != nil {
+		t.Fatal(err)
+	}
+	if queue != "mail" || priority != 10 {
+		t.Errorf("want the type defaults, got queue %q priority %d", queue, priority)
+	}
+
+	var args string
+	var gotRunAt time.Time
+	if err := c.pool.QueryRow("SELECT args::text, run_at FROM que_jobs WHERE job_class = 'MyJob'").Scan(&args, &gotRunAt); err != nil {
+		t.Fatal(err)
+	}
+	if args != `{"n":1}` || !gotRunAt.Equal(runAt) {
+		t.Errorf("want args %s at %s, got %s at %s", `{"n":1}`, runAt, args, gotRunAt)
+	}
+
+	// invalid jobs are rejected before anything is copied
+	if _, err := c.EnqueueCopy(context.Background(), []*Job{{Type: "MyJob"}, {}}); err != ErrMissingType {
+		t.Errorf("want ErrMissingType, got %v", err)
+	}
+	if n := countJobs(t, c.pool); n != 2 {
+		t.Errorf("want 2 jobs, got %d", n)
+	}
+}
+
+func TestDrainOutbox(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if _, err := c.pool.Exec("DROP TABLE IF EXISTS que_test_outbox"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.pool.Exec("CREATE TABLE que_test_outbox (id bigserial PRIMARY KEY, job_class text NOT NULL, payload json NOT NULL, error text)"); err != nil {
+		t.Fatal(err)
+	}
+	defer c.pool.Exec("DROP TABLE que_test_outbox")
+	if _, err := c.pool.Exec(`INSERT INTO que_test_outbox (job_class, payload) VALUES ('MyJob', '{"n":1}'), ('', '{}'), ('MyJob', '{"n":2}'), ('MyJob', '{"n":3}')`); err != nil {
+		t.Fatal(err)
+	}
+
+	o := Outbox{
+		Query: "SELECT id, job_class, payload::text FROM que_test_outbox WHERE error IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED",
+		Args:  []interface{}{2},
+		Scan: func(rows *pgx.Rows) (*Job, interface{}, error) {
+			var id int64
+			var jobClass, payload string
+			if err := rows.Scan(&id, &jobClass, &payload); err != nil {
+				return nil, nil, err
+			}
+			return &Job{Type: jobClass, Args: []byte(payload)}, id, nil
+		},
+		MarkProcessed: "DELETE FROM que_test_outbox WHERE id = $1",
+	}
+
+	// without MarkInvalid a row that cannot be enqueued stops the drain
+	_, err := c.DrainOutbox(context.Background(), o)
+	var outboxErr *OutboxError
+	if !errors.As(err, &outboxErr) || !errors.Is(err, ErrMissingType) {
+		t.Fatalf("want an OutboxError wrapping ErrMissingType, got %v", err)
+	}
+	if key, ok := outboxErr.Key.(int64); !ok || key != 2 {
+		t.Errorf("want the key of the invalid row, got %v", outboxErr.Key)
+	}
+	if n := countJobs(t, c.pool); n != 0 {
+		t.Errorf("want nothing enqueued, got %d jobs", n)
+	}
+
+	o.MarkInvalid = "UPDATE que_test_outbox SET error = $2 WHERE id = $1"
+	for _, want := range []int{2, 2, 0} {
+		n, err := c.DrainOutbox(context.Background(), o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("want %d rows drained, got %d", want, n)
+		}
+	}
+	if n := countJobs(t, c.pool); n != 3 {
+		t.Errorf("want 3 jobs, got %d", n)
+	}
+
+	var msg string
+	if err := c.pool.QueryRow("SELECT error FROM que_test_outbox").Scan(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg != ErrMissingType.Error() {
+		t.Errorf("want the invalid row set aside with %q, got %q", ErrMissingType, msg)
+	}
+}
+
+func TestEnqueueBatchAndReturn(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	existing := &Job{Type: "MyJob", IdempotencyKey: "dup"}
+	if err := c.Enqueue(existing); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := []*Job{
+		{Type: "First"},
+		{Type: "Skipped", IdempotencyKey: "dup"},
+		{Type: "Third", Priority: 1},
+	}
+	ids, err := c.EnqueueBatchAndReturn(context.Background(), jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != len(jobs) {
+		t.Fatalf("want %d IDs, got %v", len(jobs), ids)
+	}
+	if ids[1] != 0 {
+		t.Errorf("want ID 0 for the skipped job, got %d", ids[1])
+	}
+	for _, i := range []int{0, 2} {
+		var jobType string
+		if err = c.pool.QueryRow("SELECT job_class FROM que_jobs WHERE job_id = $1", ids[i]).Scan(&jobType); err != nil {
+			t.Fatal(err)
+		}
+		if jobType != jobs[i].Type || jobs[i].ID != ids[i] {
+			t.Errorf("want ID %d to belong to %s, got %s (job ID %d)", ids[i], jobs[i].Type, jobType, jobs[i].ID)
+		}
+	}
+
+	// a failing job rolls back the whole batch
+	_, err = c.EnqueueBatchAndReturn(context.Background(), []*Job{{Type: "Rolled back"}, {}})
+	if err != ErrMissingType {
+		t.Fatalf("want ErrMissingType, got %v", err)
+	}
+	if n, err := c.CountByType(context.Background(), "", "Rolled back"); err != nil || n != 0 {
+		t.Errorf("want the batch rolled back, got %d jobs (err %v)", n, err)
+	}
+}
+
+func TestEnqueueBatchAndReturnRollbackResetsIDs(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	first := &Job{Type: "Rolled back"}
+	_, err := c.EnqueueBatchAndReturn(context.Background(), []*Job{first, {}})
+	if err != ErrMissingType {
+		t.Fatalf("want ErrMissingType, got %v", err)
+	}
+	if first.ID != 0 {
+		t.Errorf("want ID 0 for a job whose insert was rolled back, got %d", first.ID)
+	}
+}
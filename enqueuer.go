@@ -0,0 +1,205 @@
+package que
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrEnqueuerClosed is returned by AsyncEnqueuer.Enqueue after Close was
+// called.
+var ErrEnqueuerClosed = errors.New("enqueuer is closed")
+
+// AsyncEnqueuer buffers jobs in memory and inserts them in the background,
+// one transaction per batch, either when BatchSize jobs are buffered or every
+// FlushInterval. This takes the database round trip out of hot request paths
+// at the cost of losing buffered jobs if the process dies before a flush.
+type AsyncEnqueuer struct {
+	c        *Client
+	size     int
+	interval time.Duration
+	onError  func(jobs []*Job, err error)
+
+	jobs    chan *Job
+	flushes chan flushRequest
+	quit    chan struct{}
+	stopped chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	pending sync.WaitGroup // calls of Enqueue sending a job
+	errs    sync.WaitGroup // calls of onError
+}
+
+type flushRequest struct {
+	ctx   context.Context
+	reply chan error
+}
+
+// NewAsyncEnqueuer starts an AsyncEnqueuer that inserts jobs using c in
+// batches of up to size jobs, flushing at least every interval (one second if
+// interval is not positive). Because callers of Enqueue do not wait for the
+// insert, onError is called with the jobs of any batch that could not be
+// inserted; it may be nil. It runs in a goroutine of its own, so it may
+// Enqueue the jobs again, and Close waits for it to return.
+func NewAsyncEnqueuer(c *Client, size int, interval time.Duration, onError func(jobs []*Job, err error)) *AsyncEnqueuer {
+	if size < 1 {
+		size = 1
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	e := &AsyncEnqueuer{
+		c:        c,
+		size:     size,
+		interval: interval,
+		onError:  onError,
+		jobs:     make(chan *Job, size),
+		flushes:  make(chan flushRequest),
+		quit:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Enqueue buffers j to be inserted with the next batch. It only blocks if the
+// buffer is full while a batch is being inserted. j is checked as by
+// Client.Enqueue first, and its Args are offloaded if need be, so that the
+// error of a job that cannot be enqueued is returned here rather than failing
+// the batch of other callers' jobs. Only errors of the insert itself, such as
+// an invalid DependsOn, fail a batch.
+func (e *AsyncEnqueuer) Enqueue(j *Job) error {
+	if _, err := e.c.enqueueParams(j, nil); err != nil {
+		return err
+	}
+	args, err := e.c.checkArgsSize(j)
+	if err != nil {
+		return err
+	}
+	j.Args = args
+
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return ErrEnqueuerClosed
+	}
+	e.pending.Add(1)
+	e.mu.Unlock()
+	defer e.pending.Done()
+
+	// run keeps receiving until every pending send is done, even after Close
+	e.jobs <- j
+	return nil
+}
+
+// Flush inserts all jobs buffered so far and returns the error of the insert,
+// if any, or ctx.Err() if ctx is done first.
+func (e *AsyncEnqueuer) Flush(ctx context.Context) error {
+	req := flushRequest{ctx: ctx, reply: make(chan error, 1)}
+	select {
+	case e.flushes <- req:
+	case <-e.stopped:
+		return ErrEnqueuerClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-req.reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting jobs, inserts everything still buffered and waits for
+// the background goroutine to exit or ctx to be done.
+func (e *AsyncEnqueuer) Close(ctx context.Context) error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.quit)
+	}
+	e.mu.Unlock()
+
+	select {
+	case <-e.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *AsyncEnqueuer) run() {
+	defer close(e.stopped)
+	defer e.errs.Wait()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	batch := make([]*Job, 0, e.size)
+	flush := func(ctx context.Context) error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := e.insert(ctx, batch)
+		if err != nil && e.onError != nil {
+			e.errs.Add(1)
+			go func(jobs []*Job) {
+				defer e.errs.Done()
+				e.onError(jobs, err)
+			}(batch)
+		}
+		batch = make([]*Job, 0, e.size)
+		return err
+	}
+
+	for {
+		select {
+		case j := <-e.jobs:
+			batch = append(batch, j)
+			if len(batch) >= e.size {
+				flush(context.Background())
+			}
+		case <-ticker.C:
+			flush(context.Background())
+		case req := <-e.flushes:
+			// pick up whatever was buffered before the flush was requested
+			for len(e.jobs) > 0 {
+				batch = append(batch, <-e.jobs)
+			}
+			req.reply <- flush(req.ctx)
+		case <-e.quit:
+			// no Enqueue starts sending once closed is set, so wait for
+			// the ones that did while taking their jobs
+			sent := make(chan struct{})
+			go func() {
+				e.pending.Wait()
+				close(sent)
+			}()
+			for waiting := true; waiting; {
+				select {
+				case j := <-e.jobs:
+					batch = append(batch, j)
+					if len(batch) >= e.size {
+						flush(context.Background())
+					}
+				case <-sent:
+					waiting = false
+				}
+			}
+			for len(e.jobs) > 0 {
+				batch = append(batch, <-e.jobs)
+			}
+			flush(context.Background())
+			return
+		}
+	}
+}
+
+// insert enqueues jobs in a single transaction.
+func (e *AsyncEnqueuer) insert(ctx context.Context, jobs []*Job) error {
+	return e.c.insertBatch(ctx, jobs)
+}
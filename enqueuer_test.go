@@ -0,0 +1,134 @@
+package que
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func countJobs(t testing.TB, q queryable) int {
+	var n int
+	if err := q.QueryRow("SELECT count(*) FROM que_jobs").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestAsyncEnqueuerFlush(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	e := NewAsyncEnqueuer(c, 100, time.Hour, nil)
+	defer e.Close(context.Background())
+
+	for i := 0; i < 3; i++ {
+		if err := e.Enqueue(&Job{Type: "MyJob"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := countJobs(t, c.pool); n != 3 {
+		t.Errorf("want 3 jobs after Flush, got %d", n)
+	}
+}
+
+func TestAsyncEnqueuerClose(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	e := NewAsyncEnqueuer(c, 100, time.Hour, nil)
+	if err := e.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := countJobs(t, c.pool); n != 1 {
+		t.Errorf("want 1 job after Close, got %d", n)
+	}
+	if err := e.Enqueue(&Job{Type: "MyJob"}); err != ErrEnqueuerClosed {
+		t.Errorf("want ErrEnqueuerClosed, got %v", err)
+	}
+}
+
+func TestAsyncEnqueuerOnError(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	failed := make(chan int, 1)
+	e := NewAsyncEnqueuer(c, 100, time.Hour, func(jobs []*Job, err error) {
+		failed <- len(jobs)
+	})
+	defer e.Close(context.Background())
+
+	// invalid JSON args make the whole batch fail
+	if err := e.Enqueue(&Job{Type: "MyJob", Args: []byte("{")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Flush(context.Background()); err == nil {
+		t.Fatal("want error for invalid args")
+	}
+	if n := <-failed; n != 1 {
+		t.Errorf("want OnError called with 1 job, got %d", n)
+	}
+}
+
+func TestAsyncEnqueuerValidates(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+	c.MaxArgsSize = 16
+
+	e := NewAsyncEnqueuer(c, 100, time.Hour, nil)
+	defer e.Close(context.Background())
+
+	if err := e.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+	big := []byte(`{"data":"0123456789abcdef"}`)
+	if err := e.Enqueue(&Job{Type: "MyJob", Args: big}); !errors.Is(err, ErrArgsTooLarge) {
+		t.Errorf("want ErrArgsTooLarge, got %v", err)
+	}
+
+	// the rejected job must not fail the batch of the first one
+	if err := e.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := countJobs(t, c.pool); n != 1 {
+		t.Errorf("want 1 job after Flush, got %d", n)
+	}
+}
+
+func TestAsyncEnqueuerOnErrorEnqueue(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	var e *AsyncEnqueuer
+	retried := make(chan error, 1)
+	e = NewAsyncEnqueuer(c, 1, time.Hour, func(jobs []*Job, err error) {
+		// retry the failed job with valid args
+		retried <- e.Enqueue(&Job{Type: jobs[0].Type})
+	})
+
+	if err := e.Enqueue(&Job{Type: "MyJob", Args: []byte("{")}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-retried:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("onError could not Enqueue")
+	}
+	if err := e.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := countJobs(t, c.pool); n != 1 {
+		t.Errorf("want the retried job inserted, got %d jobs", n)
+	}
+}
@@ -0,0 +1,123 @@
+package que
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// HealthError is returned by HealthCheck and names the check that failed.
+type HealthError struct {
+	// Check is one of "acquire", "ping" or "schema".
+	Check string
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *HealthError) Error() string {
+	return fmt.Sprintf("que health check %s failed: %v", e.Check, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *HealthError) Unwrap() error {
+	return e.Err
+}
+
+// errNoJobsTable is wrapped in a HealthError when que_jobs does not exist.
+var errNoJobsTable = errors.New("table que_jobs does not exist")
+
+// HealthCheck verifies that a connection can be acquired from the pool, that
+// the database answers queries and that the que_jobs table exists. It does
+// not enqueue or lock any job, so it is suitable for readiness probes. Any
+// failure is reported as a *HealthError.
+func (c *Client) HealthCheck(ctx context.Context) error {
+	conn, err := c.pool.AcquireEx(ctx)
+	if err != nil {
+		return &HealthError{Check: "acquire", Err: err}
+	}
+	defer c.pool.Release(conn)
+
+	var one int
+	if err = conn.QueryRowEx(ctx, "SELECT 1", nil).Scan(&one); err != nil {
+		return &HealthError{Check: "ping", Err: err}
+	}
+
+	var exists bool
+	if err = conn.QueryRowEx(ctx, sqlJobsTableExists, nil).Scan(&exists); err != nil {
+		return &HealthError{Check: "schema", Err: err}
+	}
+	if !exists {
+		return &HealthError{Check: "schema", Err: errNoJobsTable}
+	}
+	return nil
+}
+
+// schemaTables lists the tables and columns that the package uses, matching
+// schema.sql.
+var schemaTables = []struct {
+	name    string
+	columns []string
+}{
+	{"que_jobs", []string{
+		"priority", "run_at", "job_id", "job_class", "args", "error_count",
+		"last_error", "queue", "tags", "locked_by", "depends_on",
+		"idempotency_key", "expires_at", "deadline", "group_id",
+		"completion_webhook", "debounce_key", "worked_by_labels",
+	}},
+	{"que_queue_state", []string{"queue", "paused_at"}},
+}
+
+// SchemaError is returned by CheckSchema when the database lacks tables or
+// columns that the package needs.
+type SchemaError struct {
+	// Missing names the missing tables, and the missing columns of existing
+	// tables as table.column.
+	Missing []string
+}
+
+func (e *SchemaError) Error() string {
+	return "que schema is out of date, apply schema.sql: missing " + strings.Join(e.Missing, ", ")
+}
+
+// CheckSchema verifies that the tables and columns the package uses exist,
+// as found through the search_path like the package's queries, and returns a
+// *SchemaError listing the missing ones otherwise. Running it at startup turns
+// a schema that predates the library into a clear error rather than failing
+// scans later on.
+func (c *Client) CheckSchema(ctx context.Context) error {
+	var missing []string
+	for _, table := range schemaTables {
+		rows, err := c.pool.QueryEx(ctx, sqlTableColumns, nil, table.name)
+		if err != nil {
+			return err
+		}
+		have := make(map[string]bool)
+		for rows.Next() {
+			var column string
+			if err := rows.Scan(&column); err != nil {
+				rows.Close()
+				return err
+			}
+			have[column] = true
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if len(have) == 0 {
+			missing = append(missing, table.name)
+			continue
+		}
+		for _, column := range table.columns {
+			if !have[column] {
+				missing = append(missing, table.name+"."+column)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return &SchemaError{Missing: missing}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package que
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHealthCheck(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.HealthCheck(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHealthCheckClosedPool(t *testing.T) {
+	c := openTestClient(t)
+	c.pool.Close()
+
+	err := c.HealthCheck(context.Background())
+	herr, ok := err.(*HealthError)
+	if !ok {
+		t.Fatalf("want *HealthError, got %v", err)
+	}
+	if herr.Check != "acquire" {
+		t.Errorf("want Check=%q, got %q", "acquire", herr.Check)
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.CheckSchema(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSchemaErrorMessage(t *testing.T) {
+	err := &SchemaError{Missing: []string{"que_jobs.deadline", "que_queue_state"}}
+	want := "que schema is out of date, apply schema.sql: missing que_jobs.deadline, que_queue_state"
+	if err.Error() != want {
+		t.Errorf("want %q, got %q", want, err.Error())
+	}
+}
@@ -0,0 +1,168 @@
+package que
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/jackc/pgx"
+)
+
+// Locker is the strategy a Client uses to make sure each job is worked by
+// one worker at a time, see Client.Locker. AdvisoryLocker and
+// SkipLockedLocker implement it.
+type Locker interface {
+	// Lock runs the lock statement stmt, the name of one of the que_lock_job
+	// statements prepared by PrepareStatements, with params on conn and
+	// scans the job it locked into j. params end with the Client's
+	// AdvisoryLockClass. If no job could be locked it returns pgx.ErrNoRows.
+	Lock(ctx context.Context, conn *pgx.Conn, stmt string, j *Job, params ...interface{}) error
+
+	// Check reports whether the job j that Lock locked on conn has not been
+	// worked and deleted by another session in the meantime. A job that was
+	// is unlocked again and not worked.
+	Check(ctx context.Context, conn *pgx.Conn, j *Job) (bool, error)
+
+	// Unlock releases the lock that Lock took on j.
+	Unlock(conn *pgx.Conn, j *Job) error
+}
+
+// txLocker is implemented by the Lockers that hold a job in a transaction
+// open on its connection, which Job methods must not end with one of their
+// own.
+type txLocker interface {
+	inTx(conn *pgx.Conn) bool
+}
+
+// AdvisoryLocker locks jobs with session-level Postgres advisory locks, as
+// Ruby Que does. It is the default Locker. Because the locks belong to the
+// session, it needs a direct connection to Postgres or a pooler in session
+// mode.
+type AdvisoryLocker struct{}
+
+// Lock implements Locker.
+func (AdvisoryLocker) Lock(ctx context.Context, conn *pgx.Conn, stmt string, j *Job, params ...interface{}) error {
+	return scanJob(conn.QueryRowEx(ctx, stmt, nil, params...), j)
+}
+
+// Check implements Locker. It looks up the job again, since the lock
+// statement may have read it from a snapshot taken while another session was
+// still working it.
+func (AdvisoryLocker) Check(ctx context.Context, conn *pgx.Conn, j *Job) (bool, error) {
+	var ok bool
+	err := conn.QueryRowEx(ctx, "que_check_job", nil, j.Queue, j.Priority, j.RunAt, j.ID).Scan(&ok)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Unlock implements Locker.
+func (AdvisoryLocker) Unlock(conn *pgx.Conn, j *Job) error {
+	var ok bool
+	return conn.QueryRow("que_unlock_job", j.ID, j.lockClass).Scan(&ok)
+}
+
+// ErrTxRolledBack is returned by SkipLockedLocker.Unlock when the transaction
+// holding the job had failed, so Postgres rolled it back instead of
+// committing it.
+var ErrTxRolledBack = errors.New("job transaction was rolled back")
+
+// SkipLockedLocker locks jobs with SELECT ... FOR UPDATE SKIP LOCKED in a
+// transaction that stays open until the job is done, so it also works
+// through a pooler in transaction mode, which cannot keep advisory locks. Use
+// a pointer to a SkipLockedLocker, which may be shared between Clients.
+//
+// The writes of Job methods such as Delete and Error become part of that
+// transaction and are committed by Done. If a statement in it fails, e.g. a
+// query of the WorkFunc on Conn(), the whole transaction is rolled back, those
+// writes are lost and the job is worked again. Committing or rolling back a
+// transaction of your own on the job's connection, as RunInTx does, ends the
+// lock early. The admin functions that look for locked jobs only see advisory
+// locks, so they take jobs locked this way for unlocked ones, and those that
+// update or delete such a job, like SetPriority or PurgeByType, wait on its
+// row lock until the job is done. The AdvisoryLockClass is ignored. Workers
+// of a queue must all use the same kind of Locker, which means this one
+// cannot be combined with Ruby workers.
+type SkipLockedLocker struct {
+	mu   sync.Mutex
+	held map[*pgx.Conn]int // jobs locked in the open transaction of a conn
+}
+
+// Lock implements Locker. It begins the transaction holding the row locks
+// unless conn already holds a job, as with LockJobs, in which case it runs the
+// lock statement in a savepoint, so that a failure leaves the jobs locked
+// before intact.
+func (l *SkipLockedLocker) Lock(ctx context.Context, conn *pgx.Conn, stmt string, j *Job, params ...interface{}) error {
+	l.mu.Lock()
+	held := l.held[conn]
+	l.mu.Unlock()
+
+	begin := "BEGIN"
+	if held > 0 {
+		begin = "SAVEPOINT que_lock_job"
+	}
+	if _, err := conn.ExecEx(ctx, begin, nil); err != nil {
+		return err
+	}
+	// The skip locked statements take no lock class.
+	err := scanJob(conn.QueryRowEx(ctx, stmt+"_skip_locked", nil, params[:len(params)-1]...), j)
+	if err != nil {
+		if held == 0 {
+			_, _ = conn.Exec("ROLLBACK")
+		} else {
+			_, _ = conn.Exec("ROLLBACK TO SAVEPOINT que_lock_job")
+		}
+		return err
+	}
+	if held > 0 {
+		if _, err := conn.ExecEx(ctx, "RELEASE SAVEPOINT que_lock_job", nil); err != nil {
+			return err
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held == nil {
+		l.held = make(map[*pgx.Conn]int)
+	}
+	l.held[conn]++
+	return nil
+}
+
+// inTx implements txLocker.
+func (l *SkipLockedLocker) inTx(conn *pgx.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.held[conn] > 0
+}
+
+// Check implements Locker. A row lock is only granted on a row that still
+// exists, so there is nothing to check.
+func (l *SkipLockedLocker) Check(ctx context.Context, conn *pgx.Conn, j *Job) (bool, error) {
+	return true, nil
+}
+
+// Unlock implements Locker. It commits the transaction once the last job
+// locked in it is unlocked, and returns ErrTxRolledBack if it had failed.
+func (l *SkipLockedLocker) Unlock(conn *pgx.Conn, j *Job) error {
+	l.mu.Lock()
+	l.held[conn]--
+	held := l.held[conn]
+	if held <= 0 {
+		delete(l.held, conn)
+	}
+	l.mu.Unlock()
+
+	if held > 0 {
+		return nil
+	}
+	tag, err := conn.Exec("COMMIT")
+	if err != nil {
+		return err
+	}
+	if tag == "ROLLBACK" {
+		return ErrTxRolledBack
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package que
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx"
+)
+
+// Outbox describes a table of an application's own that it writes jobs to in
+// the transactions whose changes call for them, the transactional outbox
+// pattern, so that DrainOutbox can move them to the queue.
+type Outbox struct {
+	// Query selects the pending rows of the outbox with Args. It should
+	// lock them with FOR UPDATE SKIP LOCKED, so that concurrent drains move
+	// each row once, and LIMIT them to bound the transaction.
+	Query string
+	Args  []interface{}
+
+	// Scan reads a row selected by Query into the job to enqueue for it and
+	// a key that identifies the row to MarkProcessed.
+	Scan func(rows *pgx.Rows) (j *Job, key interface{}, err error)
+
+	// MarkProcessed is the statement that marks a row processed, e.g. by
+	// deleting it, with its key as $1.
+	MarkProcessed string
+
+	// MarkInvalid, if set, is the statement that sets aside a row whose job
+	// cannot be enqueued, e.g. because it has no Type, with its key as $1 and
+	// the error message as $2, so that it no longer blocks the outbox.
+	MarkInvalid string
+}
+
+// OutboxError is returned by DrainOutbox when the job of an outbox row could
+// not be enqueued, and tells which row it was.
+type OutboxError struct {
+	// Key is the key of the row returned by Outbox.Scan.
+	Key interface{}
+	Err error
+}
+
+func (e *OutboxError) Error() string {
+	return fmt.Sprintf("draining outbox row %v: %v", e.Key, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *OutboxError) Unwrap() error {
+	return e.Err
+}
+
+// DrainOutbox enqueues a job for each pending row of o and marks the rows
+// processed in a single transaction, so a row is either moved to the queue
+// or left pending, and returns the number of rows drained. Run it
+// periodically, or upon a notification from the transactions that write to
+// the outbox; it drains the rows Query selects once, so call it until it
+// returns 0 to empty the outbox.
+//
+// A row whose job is invalid, such as one without a Type or with too large
+// Args, is marked with MarkInvalid and counts as drained. Without
+// MarkInvalid, or if inserting the job fails in the database, nothing is
+// drained and an *OutboxError with the row's key is returned, so the caller
+// can set the row aside.
+func (c *Client) DrainOutbox(ctx context.Context, o Outbox) (int, error) {
+	tx, err := c.pool.BeginEx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryEx(ctx, o.Query, nil, o.Args...)
+	if err != nil {
+		return 0, err
+	}
+	var jobs []*Job
+	var keys []interface{}
+	for rows.Next() {
+		j, key, err := o.Scan(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		jobs = append(jobs, j)
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for i, j := range jobs {
+		mark, args := o.MarkProcessed, []interface{}{keys[i]}
+		if err := c.execEnqueue(ctx, j, tx); err != nil {
+			if o.MarkInvalid == "" || isDatabaseError(err) {
+				return 0, &OutboxError{Key: keys[i], Err: err}
+			}
+			mark, args = o.MarkInvalid, append(args, err.Error())
+		}
+		if _, err := tx.ExecEx(ctx, mark, nil, args...); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.CommitEx(ctx); err != nil {
+		return 0, err
+	}
+	return len(jobs), nil
+}
+
+// isDatabaseError reports whether err, returned by execEnqueue, comes from the
+// insert, which aborts the transaction, rather than from checking the job
+// beforehand.
+func isDatabaseError(err error) bool {
+	var enqueueErr *EnqueueError
+	return errors.As(err, &enqueueErr) || errors.Is(err, ErrInvalidDependency)
+}
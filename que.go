@@ -1,9 +1,14 @@
 package que
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/jackc/pgx"
 	"github.com/jackc/pgx/pgtype"
@@ -11,7 +16,8 @@ import (
 
 // Job is a single unit of work for Que to perform.
 type Job struct {
-	// ID is the unique database ID of the Job. It is ignored on job creation.
+	// ID is the unique database ID of the Job. It is ignored on job creation,
+	// and set to the ID of the new job once it has been enqueued.
 	ID int64
 
 	// Queue is the name of the queue. It defaults to the empty queue "".
@@ -34,6 +40,58 @@ type Job struct {
 	// Args must be the bytes of a valid JSON string
 	Args []byte
 
+	// Tags are arbitrary key/value pairs stored alongside the Job, e.g. a
+	// tenant_id or the source of the job. They can be used to select jobs with
+	// LockJobMatching.
+	Tags map[string]string
+
+	// DependsOn lists the IDs of jobs that must be finished, i.e. deleted,
+	// before this Job is locked. Jobs can only depend on jobs enqueued before
+	// them, which rules out cycles; to enqueue a chain atomically, enqueue
+	// its jobs one after the other with EnqueueInTx and use the IDs set on
+	// the earlier ones. A job whose dependency keeps failing waits for as long
+	// as the dependency is retried. It is only used on job creation.
+	DependsOn []int64
+
+	// IdempotencyKey, if set, deduplicates enqueues: while a job with the
+	// same key exists, enqueueing another one inserts nothing and leaves its
+	// ID at zero, so a non-zero ID tells that the job was newly created. Once
+	// the job is deleted, the key can be used again. It is only used on job
+	// creation.
+	IdempotencyKey string
+
+	// DebounceKey, if set along with a positive DebounceWindow, coalesces
+	// bursts of enqueues: the job runs at the end of the window unless RunAt
+	// is set, and until a job with the same key has reached its RunAt,
+	// enqueueing another one inserts nothing and leaves its ID at zero, as
+	// with IdempotencyKey. Both are only used on job creation.
+	DebounceKey    string
+	DebounceWindow time.Duration
+
+	// ExpiresAt, if set, is the time after which the job is no longer worth
+	// running: LockJob skips it from then on, and DeleteExpired deletes it.
+	// The zero value never expires.
+	ExpiresAt time.Time
+
+	// Deadline, if set, is when the job should be done by. It only affects
+	// Clients with LockOrderDeadline, which lock the job with the earliest
+	// deadline first.
+	Deadline time.Time
+
+	// GroupID, if set, adds the job to a group of related jobs, such as the
+	// jobs a task fans out to. GroupRemaining counts the jobs of a group
+	// that are left, and the Client's OnGroupDone reports when the last one
+	// is done.
+	GroupID string
+
+	// CompletionWebhook, if set, is a URL to notify once the job is done,
+	// whether it succeeded or failed for good. A Worker that finishes the job
+	// enqueues a CompletionWebhookType job in the same queue, which POSTs a
+	// CompletionNotice to the URL and is retried like any other job, so the
+	// workers of that queue must have CompletionWebhookWorkFunc in their
+	// WorkMap.
+	CompletionWebhook string
+
 	// Delay function returns the amount of seconds to wait as a function of
 	// the number of retries.
 	DelayFunction func(int32) int
@@ -46,12 +104,25 @@ type Job struct {
 	// failed. It is ignored on job creation.
 	LastError pgtype.Text
 
-	mu      sync.Mutex
-	deleted bool
+	mu          sync.Mutex
+	deleted     bool
+	rescheduled bool
+	lockedBy    string
 
-	delayFunction func(int32) int
-	pool          *pgx.ConnPool
-	conn          *pgx.Conn
+	ctx              context.Context
+	argsCodec        ArgsCodec
+	delayFunction    func(int32) int
+	lockClass        int32
+	locker           Locker
+	clock            Clock
+	onGroupDone      func(groupID string)
+	statementTimeout time.Duration
+	maxErrorLength   int
+	maxRetries       int
+	priorityStep     int16
+	batch            *lockBatch
+	pool             *pgx.ConnPool
+	conn             *pgx.Conn
 }
 
 // DelayFunction returns the amount of seconds to wait as a function of
@@ -61,11 +132,24 @@ var defaultDelayFunction = func(errorCount int32) int {
 	return intPow(int(errorCount), 4) + 3
 }
 
+// RetryJitter randomizes the delay before a failed job is retried by up to
+// this fraction in either direction, e.g. 0.2 for ±20%. This keeps a batch of
+// jobs that failed together from all retrying at the same moment. The default
+// of zero retries after exactly the delay returned by the delay function.
+var RetryJitter float64
+
+// MaxRetryDelay, if positive, caps the delay before a failed job is retried,
+// after the delay function and RetryJitter have been applied, so that the
+// default backoff saturates instead of growing into months. The default of
+// zero does not cap the delay.
+var MaxRetryDelay time.Duration
+
 // Conn returns the pgx connection that this job is locked to. You may initiate
-// transactions on this connection or use it as you please until you call
-// Done(). At that point, this conn will be returned to the pool and it is
-// unsafe to keep using it. This function will return nil if the Job's
-// connection has already been released with Done().
+// transactions on this connection, e.g. with Begin or RunInTx, copy rows with
+// CopyFrom or use it as you please until you call Done(). At that point, this
+// conn will be returned to the pool and it is unsafe to keep using it. This
+// function will return nil if the Job's connection has already been released
+// with Done().
 func (j *Job) Conn() *pgx.Conn {
 	j.mu.Lock()
 	defer j.mu.Unlock()
@@ -73,55 +157,301 @@ func (j *Job) Conn() *pgx.Conn {
 	return j.conn
 }
 
-// Delete marks this job as complete by deleting it form the database.
+// Context returns the context the job was locked with, carrying the job's
+// JobInfo for FromContext. For jobs run by a Worker this is the Worker's
+// context, so it is done when the Worker is asked to stop through it. Jobs
+// that were not locked have a background context.
+func (j *Job) Context() context.Context {
+	if j.ctx == nil {
+		return newJobContext(context.Background(), j)
+	}
+	return j.ctx
+}
+
+// ErrJobNotLocked is returned by the methods of a Job that need its database
+// connection when the job is not locked, either because Done was already
+// called or because it is a snapshot such as the one returned by PeekNext.
+var ErrJobNotLocked = errors.New("job is not locked")
+
+// Delete marks this job as complete by deleting it form the database. It does
+// not use the job's Context, so it succeeds even once that is done, e.g.
+// when the Worker is shutting down.
 //
 // You must also later call Done() to return this job's database connection to
 // the pool.
 func (j *Job) Delete() error {
+	return j.Finish(context.Background())
+}
+
+// Finish marks the job as successfully worked. It does the same as Delete,
+// under a name that does not suggest the job was cancelled, but stops waiting
+// for the database once ctx is done. Pass a context that is not the job's own
+// if the job must be finished even while the Worker stops.
+//
+// You must also later call Done() to return this job's database connection to
+// the pool.
+func (j *Job) Finish(ctx context.Context) error {
+	groupDone, err := j.delete(ctx)
+	if groupDone {
+		j.onGroupDone(j.GroupID)
+	}
+	return err
+}
+
+func (j *Job) delete(ctx context.Context) (groupDone bool, err error) {
 	j.mu.Lock()
 	defer j.mu.Unlock()
 
 	if j.deleted {
-		return nil
+		return false, nil
+	}
+	if j.conn == nil {
+		return false, ErrJobNotLocked
 	}
 
-	_, err := j.conn.Exec("que_destroy_job", j.Queue, j.Priority, j.RunAt, j.ID)
+	if t, ok := j.locker.(txLocker); ok && t.inTx(j.conn) {
+		// The locker's transaction is open, and committing or rolling back
+		// one of our own would end it, releasing the other jobs it holds.
+		if groupDone, err = j.destroy(ctx, j.conn); err != nil {
+			return false, err
+		}
+	} else if j.GroupID != "" && j.onGroupDone != nil {
+		tx, err := j.conn.BeginEx(ctx, nil)
+		if err != nil {
+			return false, err
+		}
+		defer tx.Rollback()
+
+		if groupDone, err = j.destroy(ctx, tx); err != nil {
+			return false, err
+		}
+		if err = tx.CommitEx(ctx); err != nil {
+			return false, err
+		}
+	} else {
+		_, err := j.conn.ExecEx(ctx, "que_destroy_job", nil, j.Queue, j.Priority, j.RunAt, j.ID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	j.deleted = true
+	j.lockedBy = ""
+	return groupDone, nil
+}
+
+// destroy deletes the job in tx. If the Client reports the completion of the
+// job's group, it first takes the group's lock, which serializes the deletes
+// of the group's jobs until their transactions commit, so that exactly one of
+// them finds the group empty afterwards, and reports whether this one did.
+func (j *Job) destroy(ctx context.Context, tx txQueryer) (groupDone bool, err error) {
+	grouped := j.GroupID != "" && j.onGroupDone != nil
+	if grouped {
+		if _, err = tx.ExecEx(ctx, "que_lock_group", nil, j.lockClass, j.GroupID); err != nil {
+			return false, err
+		}
+	}
+	if _, err = tx.ExecEx(ctx, "que_destroy_job", nil, j.Queue, j.Priority, j.RunAt, j.ID); err != nil {
+		return false, err
+	}
+	if !grouped {
+		return false, nil
+	}
+
+	var remaining int
+	err = tx.QueryRowEx(ctx, "que_group_remaining", nil, j.GroupID).Scan(&remaining)
+	return remaining == 0, err
+}
+
+// RunInTx runs fn in a transaction on the job's connection and deletes the
+// job in the same transaction, committing only if fn returns nil. This way the
+// job stays enqueued unless the database writes of fn are committed. If fn or
+// the commit fails, the transaction is rolled back and the error is returned,
+// so a WorkFunc can return it to have the job retried.
+//
+// fn must use tx rather than Conn() while it runs. You must also later call
+// Done() to return this job's database connection to the pool.
+func (j *Job) RunInTx(ctx context.Context, fn func(tx *pgx.Tx) error) error {
+	conn := j.Conn()
+	if conn == nil {
+		return ErrJobNotLocked
+	}
+
+	tx, err := conn.BeginEx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	j.deleted = true
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	groupDone, err := j.destroy(ctx, tx)
+	if err == nil {
+		err = tx.CommitEx(ctx)
+	}
+	if err == nil {
+		j.deleted = true
+		j.lockedBy = ""
+	}
+	j.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if groupDone {
+		j.onGroupDone(j.GroupID)
+	}
 	return nil
 }
 
-// Done releases the Postgres advisory lock on the job and returns the database
-// connection to the pool.
+// IsDeleted reports whether Delete has already succeeded for this job.
+func (j *Job) IsDeleted() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.deleted
+}
+
+// jobJSON is the JSON form of a Job, see MarshalJSON.
+type jobJSON struct {
+	ID                int64             `json:"id"`
+	Queue             string            `json:"queue"`
+	Priority          int16             `json:"priority"`
+	RunAt             time.Time         `json:"run_at"`
+	Type              string            `json:"type"`
+	Args              json.RawMessage   `json:"args"`
+	Tags              map[string]string `json:"tags,omitempty"`
+	DependsOn         []int64           `json:"depends_on,omitempty"`
+	IdempotencyKey    string            `json:"idempotency_key,omitempty"`
+	ExpiresAt         *time.Time        `json:"expires_at,omitempty"`
+	Deadline          *time.Time        `json:"deadline,omitempty"`
+	GroupID           string            `json:"group_id,omitempty"`
+	CompletionWebhook string            `json:"completion_webhook,omitempty"`
+	ErrorCount        int32             `json:"error_count"`
+	LastError         *string           `json:"last_error"`
+}
+
+// MarshalJSON encodes the job's fields for API responses, with snake_case
+// keys, Args embedded as JSON and last_error null unless the job failed. It
+// leaves out the database state of a locked job.
+func (j *Job) MarshalJSON() ([]byte, error) {
+	v := jobJSON{
+		ID:                j.ID,
+		Queue:             j.Queue,
+		Priority:          j.Priority,
+		RunAt:             j.RunAt,
+		Type:              j.Type,
+		Args:              json.RawMessage(j.Args),
+		Tags:              j.Tags,
+		DependsOn:         j.DependsOn,
+		IdempotencyKey:    j.IdempotencyKey,
+		GroupID:           j.GroupID,
+		CompletionWebhook: j.CompletionWebhook,
+		ErrorCount:        j.ErrorCount,
+	}
+	if len(j.Args) == 0 {
+		v.Args = json.RawMessage("null")
+	}
+	if !j.ExpiresAt.IsZero() {
+		v.ExpiresAt = &j.ExpiresAt
+	}
+	if !j.Deadline.IsZero() {
+		v.Deadline = &j.Deadline
+	}
+	if j.LastError.Status == pgtype.Present {
+		v.LastError = &j.LastError.String
+	}
+	return json.Marshal(v)
+}
+
+// Done releases the lock on the job and returns the database
+// connection to the pool. Jobs locked by a Worker as part of a batch share a
+// connection that the Worker returns to the pool after the whole batch.
 func (j *Job) Done() {
 	j.mu.Lock()
 	defer j.mu.Unlock()
 
-	if j.conn == nil || j.pool == nil {
+	if j.conn == nil {
 		// already marked as done
 		return
 	}
 
-	var ok bool
-	// Swallow this error because we don't want an unlock failure to cause work to
-	// stop.
-	_ = j.conn.QueryRow("que_unlock_job", j.ID).Scan(&ok)
+	// Swallow or log these errors because we don't want an unlock failure to
+	// cause work to stop.
+	if j.lockedBy != "" {
+		_, _ = j.conn.Exec("que_set_locked_by", nil, j.Queue, j.Priority, j.RunAt, j.ID)
+		j.lockedBy = ""
+	}
+	if err := j.locker.Unlock(j.conn, j); err != nil {
+		log.Printf("attempting to unlock job %d: %v", j.ID, err)
+	}
 
-	j.pool.Release(j.conn)
+	if j.pool != nil {
+		j.pool.Release(j.conn)
+	}
+	if j.batch != nil {
+		j.batch.done()
+	}
 	j.pool = nil
+	j.batch = nil
 	j.conn = nil
 }
 
+// lockBatch returns the connection shared by the jobs locked with LockJobs to
+// the pool once all of them are done.
+type lockBatch struct {
+	mu      sync.Mutex
+	pool    *pgx.ConnPool
+	conn    *pgx.Conn
+	pending int
+}
+
+func (b *lockBatch) done() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending--
+	if b.pending == 0 {
+		b.pool.Release(b.conn)
+	}
+}
+
+// DoneAll calls Done on each of jobs, such as the ones returned by LockJobs.
+func DoneAll(jobs []*Job) {
+	for _, j := range jobs {
+		j.Done()
+	}
+}
+
 // Error marks the job as failed and schedules it to be reworked. An error
 // message or backtrace can be provided as msg, which will be saved on the job.
 // It will also increase the error count.
 //
+// On success the job's RunAt, ErrorCount and LastError are updated to the
+// values that were saved, so RunAt tells when the job will be retried. Like
+// Delete, it does not use the job's Context.
+//
+// A job that has used up the MaxRetries of its type is deleted instead, so
+// IsDeleted reports true afterwards.
+//
 // You must also later call Done() to return this job's database connection to
 // the pool.
 func (j *Job) Error(msg string) error {
+	if j.maxRetries > 0 && int(j.ErrorCount) >= j.maxRetries {
+		return j.Delete()
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.conn == nil {
+		return ErrJobNotLocked
+	}
+
+	msg = truncateError(msg, j.maxErrorLength)
 	errorCount := j.ErrorCount + 1
 
 	var delay int
@@ -130,85 +460,950 @@ func (j *Job) Error(msg string) error {
 	} else {
 		delay = j.delayFunction(j.ErrorCount)
 	}
+	delay = jitter(delay, RetryJitter)
+	if max := int(MaxRetryDelay / time.Second); MaxRetryDelay > 0 && delay > max {
+		delay = max
+	}
+
+	if j.statementTimeout > 0 {
+		if err := setStatementTimeout(j.conn, j.statementTimeout); err != nil {
+			return err
+		}
+		defer resetStatementTimeout(j.conn)
+	}
+
+	// Without a Clock the retry time is based on the database clock.
+	var base time.Time
+	if j.clock != nil {
+		base = j.clock.Now()
+	}
+
+	var step int16
+	if j.priorityStep > 0 {
+		step = j.priorityStep
+	}
+
+	var runAt time.Time
+	var priority int16
+	err := j.conn.QueryRow("que_set_error", errorCount, delay, msg, j.Queue, j.Priority, j.RunAt, j.ID, nullTime(base), step).Scan(&runAt, &priority)
+	if err == pgx.ErrNoRows {
+		// the job is gone, so there is nothing to reschedule
+		return nil
+	}
+	if err != nil {
+		return statementTimeoutError(err)
+	}
+	j.RunAt = runAt
+	j.Priority = priority
+	j.ErrorCount = errorCount
+	j.LastError = pgtype.Text{String: msg, Status: pgtype.Present}
+	j.lockedBy = ""
+	return nil
+}
+
+// errorEllipsis marks a last_error that was cut to fit MaxErrorLength.
+const errorEllipsis = "..."
+
+// truncateError shortens msg to at most max bytes, ending it with
+// errorEllipsis, without splitting a UTF-8 sequence. A max of zero or less
+// leaves msg alone.
+func truncateError(msg string, max int) string {
+	if max <= 0 || len(msg) <= max {
+		return msg
+	}
+	if max <= len(errorEllipsis) {
+		return errorEllipsis[:max]
+	}
+	n := max - len(errorEllipsis)
+	for n > 0 && !utf8.RuneStart(msg[n]) {
+		n--
+	}
+	return msg[:n] + errorEllipsis
+}
+
+// UpdateArgs replaces the job's Args with args, both in the database and in
+// j, leaving its ErrorCount and RunAt alone. A WorkFunc can use it to save
+// its progress, so that when the job is retried after an error it resumes
+// from the saved Args instead of starting over. args must be valid JSON.
+//
+// It returns ErrJobNotLocked if the job is not locked.
+func (j *Job) UpdateArgs(ctx context.Context, args []byte) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.conn == nil {
+		return ErrJobNotLocked
+	}
+
+	_, err := j.conn.ExecEx(ctx, "que_update_args", nil, args, j.Queue, j.Priority, j.RunAt, j.ID)
+	if err != nil {
+		return err
+	}
+	j.Args = args
+	return nil
+}
+
+// Reschedule postpones the job to runAt without counting it as a failure:
+// unlike Error it leaves ErrorCount and LastError alone and does not apply the
+// delay function. It is meant for jobs that cannot run yet, for instance
+// because something they depend on is not ready. On success j.RunAt is set to
+// the saved value.
+//
+// A Worker does not delete a job that was rescheduled by its WorkFunc, as
+// long as the WorkFunc returns nil.
+//
+// You must also later call Done() to return this job's database connection to
+// the pool.
+func (j *Job) Reschedule(ctx context.Context, runAt time.Time) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.conn == nil {
+		return ErrJobNotLocked
+	}
 
-	_, err := j.conn.Exec("que_set_error", errorCount, delay, msg, j.Queue, j.Priority, j.RunAt, j.ID)
+	var savedRunAt time.Time
+	err := j.conn.QueryRowEx(ctx, "que_reschedule_job", nil, runAt, j.Queue, j.Priority, j.RunAt, j.ID).Scan(&savedRunAt)
+	if err == pgx.ErrNoRows {
+		// the job is gone, so there is nothing to reschedule
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	j.RunAt = savedRunAt
+	j.rescheduled = true
+	j.lockedBy = ""
 	return nil
 }
 
+// isRescheduled reports whether Reschedule has succeeded for this job.
+func (j *Job) isRescheduled() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.rescheduled
+}
+
+// setLockedBy records workerID as the worker of this job until it is done.
+func (j *Job) setLockedBy(workerID string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.conn == nil {
+		return ErrJobNotLocked
+	}
+
+	_, err := j.conn.Exec("que_set_locked_by", workerID, j.Queue, j.Priority, j.RunAt, j.ID)
+	if err != nil {
+		return err
+	}
+	j.lockedBy = workerID
+	return nil
+}
+
+// setWorkedByLabels records the labels of the worker working this job. Unlike
+// locked_by, they are kept when the job is retried.
+func (j *Job) setWorkedByLabels(labels map[string]string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.conn == nil {
+		return ErrJobNotLocked
+	}
+
+	v := &pgtype.JSONB{}
+	if err := v.Set(labels); err != nil {
+		return err
+	}
+	_, err := j.conn.Exec("que_set_worked_by_labels", v, j.Queue, j.Priority, j.RunAt, j.ID)
+	return err
+}
+
 // Client is a Que client that can add jobs to the queue and remove jobs from
 // the queue.
 type Client struct {
+	// AcquireTimeout limits how long LockJob waits for a connection from the
+	// pool. If no connection becomes available in time, ErrPoolBusy is
+	// returned. The default of zero waits as long as the context allows.
+	AcquireTimeout time.Duration
+
+	// AdvisoryLockClass namespaces the advisory locks taken on jobs, so they
+	// cannot collide with other uses of pg_advisory_lock in the application.
+	// With the default of zero a job is locked with its job_id as the key,
+	// exactly like Ruby Que does. Otherwise the key is the class in the upper
+	// 32 bits and the lower 32 bits of the job_id in the lower ones, so jobs
+	// whose IDs differ by a multiple of 2^32 share a lock. Workers of a queue
+	// must all use the same class, which means a non-zero class cannot be
+	// combined with Ruby workers on the same queue.
+	AdvisoryLockClass int32
+
+	// NotifyChannel, if set, makes every enqueued job send a Postgres
+	// notification on this channel once it is committed. The payload is a
+	// JSON object with the job's queue and ID, see ParseJobNotification, so
+	// listeners can pick up new jobs without polling. Enqueueing does not
+	// notify when it is empty, the default.
+	NotifyChannel string
+
+	// OnLockRace, if set, is called whenever locking a job hits the race where
+	// the job was worked and deleted by another worker between being selected
+	// and being locked. attempt counts the attempts of this lock so far,
+	// starting at 1; locking is retried after every race until ErrAgain is
+	// returned after maxLockJobAttempts. A lock that succeeds after a call
+	// with attempt n took n+1 attempts. It must be safe for concurrent use.
+	OnLockRace func(attempt int)
+
+	// StatementTimeout, if positive, sets the Postgres statement_timeout for
+	// the queries that lock a job and the one Job.Error uses to reschedule
+	// it, so a query stuck on lock contention cannot hang a worker. A query
+	// that runs into it fails with an error wrapping ErrStatementTimeout. The
+	// setting is reset to the session default afterwards, so it does not
+	// apply to the queries of a WorkFunc. It costs two extra round trips per
+	// lock, so it is off by default.
+	StatementTimeout time.Duration
+
+	// MaxErrorLength, if positive, caps the length in bytes of the message
+	// that Job.Error stores in last_error for the jobs this Client locks, so
+	// huge stack traces do not bloat que_jobs. Longer messages are cut and
+	// end in "...". Job.LastError holds the stored message. The default of
+	// zero stores messages in full; 8192 is a reasonable cap.
+	MaxErrorLength int
+
+	// DisableRaceCheck skips the check_job query that LockJob runs after
+	// locking a job, saving a round trip per lock. That query catches a job
+	// that another session deleted between LockJob's snapshot and taking the
+	// advisory lock; without it such a job would be worked a second time.
+	// Only set it if nothing else deletes jobs of the Client's queues while
+	// it locks them, e.g. one Worker without Ruby workers or admin purges.
+	DisableRaceCheck bool
+
+	// Locker is the strategy used to lock jobs. It defaults to an
+	// AdvisoryLocker, which needs a direct connection to Postgres or a pooler
+	// in session mode. Use a *SkipLockedLocker behind a pooler in
+	// transaction mode.
+	Locker Locker
+
+	// OnGroupDone, if set, is called with the GroupID of a job once it was
+	// the last job of its group to be deleted by Delete or RunInTx, on the
+	// goroutine that deleted it. Jobs deleted otherwise, e.g. by
+	// DeleteExpired, are not reported. Reporting takes a transaction and a
+	// lock per deleted job of a group, which serialize the deletes of the
+	// group's jobs. With a SkipLockedLocker the delete and the group's lock
+	// are part of the locker's transaction instead, so the report comes
+	// before Done commits the delete. It must be safe for concurrent use.
+	OnGroupDone func(groupID string)
+
+	// Clock, if set, is used instead of the system clock wherever the Client,
+	// its Workers and the jobs they lock read the time in Go: for the RunAt
+	// of EnqueueNow, the retry time computed by Job.Error, which is then
+	// based on the Clock rather than on the database's now(), and the waits
+	// and durations passed to Worker hooks and rate limiters. Whether a job
+	// is ready or expired is still decided by the database clock when it is
+	// locked. It is meant for tests.
+	Clock Clock
+
+	// MaxArgsSize, if positive, is the maximum length in bytes of the Args
+	// of an enqueued job, to keep oversized payloads out of que_jobs.
+	// Enqueueing a job with longer Args fails with an error wrapping
+	// ErrArgsTooLarge, unless OffloadArgs is set. The default of zero
+	// allows any size.
+	MaxArgsSize int
+
+	// OffloadArgs, if set, is called for a job whose Args exceed MaxArgsSize.
+	// It can store them elsewhere, such as in a blob store, and return a
+	// reference to insert as the job's args instead; j itself is not
+	// changed. The WorkFunc is responsible for resolving the reference. An
+	// error from it, or a reference that still exceeds MaxArgsSize, fails
+	// the enqueue.
+	OffloadArgs func(j *Job) ([]byte, error)
+
+	// LockOrder is the order in which LockJob and PeekNext pick the jobs of a
+	// queue, LockOrderPriority by default.
+	LockOrder LockOrder
+
+	// OnJobExpired, if set, is called with every job deleted by
+	// DeleteExpired, so missed jobs can be recorded.
+	OnJobExpired func(j *Job)
+
+	// DryRun makes the maintenance methods PurgeByType, DeleteOlderThan,
+	// MoveQueue, AgePriorities and DeleteExpired only report how many jobs they would
+	// change: the change runs in a transaction that is rolled back, so the
+	// count follows the same rules, e.g. skipping locked jobs. The affected
+	// rows stay locked until the rollback. To check before a real run, use a
+	// copy of the Client with DryRun set.
+	DryRun bool
+
+	// DefaultQueue is the queue of enqueued jobs that leave Job.Queue empty.
+	// Use WithQueue("") to enqueue a job in the nameless queue "" regardless.
+	DefaultQueue string
+
+	// Types holds the defaults of each job type, keyed by type, see
+	// RegisterType.
+	Types map[string]TypeOptions
+
+	// RequireRegisteredTypes, if set, makes enqueueing a job whose Type is
+	// not in Types fail with ErrUnregisteredType, which catches typos and
+	// jobs for removed WorkFuncs before they pile up. It has no effect while
+	// Types is empty, and CompletionWebhookType jobs are always allowed.
+	RequireRegisteredTypes bool
+
+	// ArgsCodec encodes the values passed to EnqueueValue and decodes them in
+	// Job.UnmarshalArgs for the jobs this Client locks. It defaults to
+	// encoding/json.
+	ArgsCodec ArgsCodec
+
 	pool *pgx.ConnPool
 
 	// TODO: add a way to specify default queueing options
 }
 
+// LockOrder is an order in which a Client locks jobs. In every order jobs
+// whose run_at is in the future are not locked, and jobs that are otherwise
+// equal are locked in the order they were enqueued.
+type LockOrder int
+
+const (
+	// LockOrderPriority locks the job with the lowest Priority first, and
+	// the one with the oldest RunAt among jobs of the same priority. It is
+	// the default, and the order of Ruby Que.
+	LockOrderPriority LockOrder = iota
+
+	// LockOrderFIFO locks the job with the oldest RunAt first, ignoring
+	// Priority. Unlike LockOrderPriority it is not backed by the primary key
+	// of que_jobs, so locking gets slower as queues grow; it is best suited
+	// to short queues. Workers of a queue should all use the same order.
+	LockOrderFIFO
+
+	// LockOrderDeadline locks the job with the earliest Deadline first, for
+	// earliest deadline first scheduling, then follows LockOrderPriority.
+	// Jobs without a Deadline come after all the others. Like LockOrderFIFO
+	// it is not backed by an index.
+	LockOrderDeadline
+)
+
 // NewClient creates a new Client that uses the pgx pool.
 func NewClient(pool *pgx.ConnPool) *Client {
 	return &Client{pool: pool}
 }
 
+// ErrInvalidPriority is returned when you attempt to enqueue a job with a
+// negative priority. Priorities range from 0, the highest, to 32767.
+var ErrInvalidPriority = errors.New("job priority must not be negative")
+
+// PoolStats returns the connection statistics of the Client's pool, so that
+// applications can tell when workers are starved of connections.
+func (c *Client) PoolStats() pgx.ConnPoolStat {
+	return c.pool.Stat()
+}
+
+// ErrArgsTooLarge is returned when you attempt to enqueue a job whose Args
+// are longer than the Client's MaxArgsSize.
+var ErrArgsTooLarge = errors.New("job args are too large")
+
+// ErrInvalidDependency is returned when you attempt to enqueue a job whose
+// DependsOn contains an ID that is not lower than its own, which would allow
+// dependency cycles.
+var ErrInvalidDependency = errors.New("a job can only depend on jobs enqueued before it")
+
+// Enqueuer is implemented by types that can add jobs to a queue, such as
+// Client. Code that only enqueues jobs can depend on it, so unit tests can
+// pass a fake like the one in the quefake package instead of a Client backed
+// by a database.
+type Enqueuer interface {
+	Enqueue(j *Job, opts ...EnqueueOption) error
+}
+
+// JobQueue is the set of Client methods used to enqueue and lock jobs. Code
+// that depends on it rather than on *Client can be given a mock in tests.
+type JobQueue interface {
+	Enqueuer
+	EnqueueInTx(j *Job, tx *pgx.Tx, opts ...EnqueueOption) error
+	LockJobContext(ctx context.Context, queue string) (*Job, error)
+}
+
+var _ JobQueue = (*Client)(nil)
+
 // ErrMissingType is returned when you attempt to enqueue a job with no Type
 // specified.
 var ErrMissingType = errors.New("job type must be specified")
 
-// Enqueue adds a job to the queue.
-func (c *Client) Enqueue(j *Job) error {
-	return execEnqueue(j, c.pool)
+// ErrUnregisteredType is returned when you attempt to enqueue a job whose Type
+// was not registered with a Client that has RequireRegisteredTypes set.
+var ErrUnregisteredType = errors.New("job type is not registered")
+
+// EnqueueError is returned when inserting a job fails with a database error,
+// and tells which job it was. Invalid jobs are rejected with the errors
+// describing what is wrong with them instead, such as ErrMissingType.
+type EnqueueError struct {
+	// Queue and Type are the queue and type the job was enqueued with.
+	Queue string
+	Type  string
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *EnqueueError) Error() string {
+	return fmt.Sprintf("enqueueing job of type %q in queue %q: %v", e.Type, e.Queue, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *EnqueueError) Unwrap() error {
+	return e.Err
+}
+
+// Enqueue adds a job to the queue and sets j.ID to the ID of the new job. If
+// no job was created because of j's IdempotencyKey or DebounceKey, it returns
+// nil and sets j.ID to 0, so a non-zero ID tells that the job is new. Any
+// opts override the corresponding fields of j for this insert only; they are
+// not copied to j.
+func (c *Client) Enqueue(j *Job, opts ...EnqueueOption) error {
+	return c.execEnqueue(context.Background(), j, c.pool, opts...)
+}
+
+// EnqueueContext is like Enqueue, but inserts the job within the transaction
+// attached to ctx with WithTx, if any, like EnqueueInTx, and cancels the
+// insert once ctx is done. It lets middleware manage a request's transaction
+// while handlers enqueue jobs the same way with or without one.
+func (c *Client) EnqueueContext(ctx context.Context, j *Job, opts ...EnqueueOption) error {
+	if tx, ok := TxFromContext(ctx); ok {
+		return c.execEnqueue(ctx, j, tx, opts...)
+	}
+	return c.execEnqueue(ctx, j, c.pool, opts...)
+}
+
+// enqueueNowOffset is how far in the past EnqueueNow schedules a job. It is
+// generous enough to absorb small clock differences between the application
+// and the database.
+const enqueueNowOffset = time.Second
+
+// EnqueueNow adds a job to the queue with its RunAt set slightly in the past,
+// overwriting any RunAt already set on j. Jobs are locked in order of
+// priority, then run_at, then job_id, so the job is picked up ahead of jobs of
+// the same priority that were enqueued to run now, even ones inserted in the
+// same instant.
+func (c *Client) EnqueueNow(j *Job) error {
+	j.RunAt = c.now().Add(-enqueueNowOffset)
+	return c.execEnqueue(context.Background(), j, c.pool)
 }
 
 // EnqueueInTx adds a job to the queue within the scope of the transaction tx.
 // This allows you to guarantee that an enqueued job will either be committed or
 // rolled back atomically with other changes in the course of this transaction.
+// Any opts are applied as in Enqueue, and j.ID is set, or set to 0 for a
+// job that was not created, as by Enqueue.
+//
+// tx is a plain *pgx.Tx, as returned by Begin on a pgx.ConnPool or pgx.Conn.
+// It need not come from the Client's pool, but its connection must have the
+// que statements prepared, e.g. with PrepareStatements as the AfterConnect
+// hook of its pool.
 //
 // It is the caller's responsibility to Commit or Rollback the transaction after
 // this function is called.
-func (c *Client) EnqueueInTx(j *Job, tx *pgx.Tx) error {
-	return execEnqueue(j, tx)
+func (c *Client) EnqueueInTx(j *Job, tx *pgx.Tx, opts ...EnqueueOption) error {
+	return c.execEnqueue(context.Background(), j, tx, opts...)
 }
 
-func execEnqueue(j *Job, q queryable) error {
-	if j.Type == "" {
-		return ErrMissingType
+// EnqueueBatchAndReturn enqueues jobs in a single transaction, so either all
+// or none of them are added, and returns their IDs in the order of jobs. The
+// ID of a job is also set on it, as by Enqueue. A job that was skipped because
+// of its IdempotencyKey has the ID 0, and so do all of them if an error is
+// returned.
+func (c *Client) EnqueueBatchAndReturn(ctx context.Context, jobs []*Job) ([]int64, error) {
+	if err := c.insertBatch(ctx, jobs); err != nil {
+		return nil, err
+	}
+	ids := make([]int64, len(jobs))
+	for i, j := range jobs {
+		ids[i] = j.ID
 	}
+	return ids, nil
+}
 
-	queue := &pgtype.Text{
-		String: j.Queue,
-		Status: pgtype.Null,
+// insertBatch enqueues jobs in a single transaction. If it fails, the IDs of
+// the jobs inserted before the transaction was rolled back are reset.
+func (c *Client) insertBatch(ctx context.Context, jobs []*Job) (err error) {
+	defer func() {
+		if err != nil {
+			for _, j := range jobs {
+				j.ID = 0
+			}
+		}
+	}()
+
+	tx, err := c.pool.BeginEx(ctx, nil)
+	if err != nil {
+		return err
 	}
-	if j.Queue != "" {
-		queue.Status = pgtype.Present
+	defer tx.Rollback()
+
+	for _, j := range jobs {
+		if err = c.execEnqueue(ctx, j, tx); err != nil {
+			return err
+		}
 	}
+	return tx.CommitEx(ctx)
+}
 
-	priority := &pgtype.Int2{
-		Int:    j.Priority,
-		Status: pgtype.Null,
+// copyColumns are the columns of que_jobs written by EnqueueCopy.
+var copyColumns = []string{
+	"queue", "priority", "run_at", "job_class", "args", "tags", "depends_on",
+	"idempotency_key", "expires_at", "deadline", "group_id", "completion_webhook",
+}
+
+// EnqueueCopy enqueues jobs with a single COPY, which is much faster than
+// inserting them for bulk loads of many thousands of jobs, and returns the
+// number of jobs copied. Either all or none of them are added. All jobs are
+// checked as by Enqueue before anything is sent, and they get the same
+// defaults as with Enqueue, except that a zero RunAt is the time of the call.
+//
+// COPY cannot skip rows, so the IDs of the jobs are not set, a duplicate
+// IdempotencyKey fails the whole copy, jobs with a DebounceKey are rejected
+// and no notifications are sent on the NotifyChannel. The copy only returns
+// early for ctx between rows.
+func (c *Client) EnqueueCopy(ctx context.Context, jobs []*Job) (int64, error) {
+	now := c.now()
+	rows := make([][]interface{}, len(jobs))
+	for i, j := range jobs {
+		if j.DebounceKey != "" {
+			return 0, fmt.Errorf("EnqueueCopy cannot debounce job %d of type %q", i, j.Type)
+		}
+		p, err := c.enqueueParams(j, nil)
+		if err != nil {
+			return 0, err
+		}
+		args, err := c.checkArgsSize(j)
+		if err != nil {
+			return 0, err
+		}
+		rows[i], err = copyRow(j, p, args, now)
+		if err != nil {
+			return 0, err
+		}
 	}
-	if j.Priority != 0 {
-		priority.Status = pgtype.Present
+
+	n, err := c.pool.CopyFrom(pgx.Identifier{"que_jobs"}, copyColumns, &copySource{ctx: ctx, rows: rows, i: -1})
+	return int64(n), err
+}
+
+// copyRow returns the copyColumns of j, filling in the defaults that
+// sqlInsertJob applies.
+func copyRow(j *Job, p *enqueueParams, args []byte, now time.Time) ([]interface{}, error) {
+	queue := p.queue.String
+	priority := int16(100)
+	if p.priority.Status == pgtype.Present {
+		priority = p.priority.Int
+	}
+	runAt := now
+	if p.runAt.Status == pgtype.Present {
+		runAt = p.runAt.Time
 	}
+	if len(args) == 0 {
+		args = []byte("[]")
+	}
+	tags := &pgtype.JSONB{Bytes: []byte("{}"), Status: pgtype.Present}
+	if len(j.Tags) != 0 {
+		if err := tags.Set(j.Tags); err != nil {
+			return nil, err
+		}
+	}
+	dependsOn := []int64{}
+	if len(j.DependsOn) != 0 {
+		dependsOn = j.DependsOn
+	}
+	return []interface{}{
+		queue,
+		priority,
+		runAt,
+		j.Type,
+		&pgtype.JSON{Bytes: args, Status: pgtype.Present},
+		tags,
+		dependsOn,
+		nullText(j.IdempotencyKey),
+		nullTime(j.ExpiresAt),
+		nullTime(j.Deadline),
+		nullText(j.GroupID),
+		nullText(j.CompletionWebhook),
+	}, nil
+}
 
-	runAt := &pgtype.Timestamptz{
-		Time:   j.RunAt,
-		Status: pgtype.Null,
+// copySource is the pgx.CopyFromSource of EnqueueCopy, which stops the copy
+// once ctx is done.
+type copySource struct {
+	ctx  context.Context
+	rows [][]interface{}
+	i    int
+}
+
+func (s *copySource) Next() bool {
+	s.i++
+	return s.ctx.Err() == nil && s.i < len(s.rows)
+}
+
+func (s *copySource) Values() ([]interface{}, error) {
+	return s.rows[s.i], nil
+}
+
+func (s *copySource) Err() error {
+	return s.ctx.Err()
+}
+
+// An EnqueueOption overrides a field of the Job being enqueued. Options are
+// applied in order, so a later option wins over an earlier one, and a slice of
+// options can be shared between calls to Enqueue and EnqueueInTx.
+type EnqueueOption func(*enqueueParams)
+
+// WithQueue enqueues the job in queue instead of j.Queue or the Client's
+// DefaultQueue. WithQueue("") selects the nameless queue "".
+func WithQueue(queue string) EnqueueOption {
+	return func(p *enqueueParams) {
+		p.queue = pgtype.Text{String: queue, Status: pgtype.Present}
+	}
+}
+
+// WithPriority enqueues the job with priority instead of j.Priority. Unlike
+// j.Priority, where zero selects the default of 100, it can be used to enqueue
+// a job with priority 0, the highest possible.
+func WithPriority(priority int16) EnqueueOption {
+	return func(p *enqueueParams) {
+		p.priority = pgtype.Int2{Int: priority, Status: pgtype.Present}
+	}
+}
+
+// WithRunAt enqueues the job to run at runAt instead of j.RunAt.
+func WithRunAt(runAt time.Time) EnqueueOption {
+	return func(p *enqueueParams) {
+		p.runAt = pgtype.Timestamptz{Time: runAt, Status: pgtype.Present}
+	}
+}
+
+// WithAsyncCommit turns off synchronous_commit for the transaction that
+// inserts the job, so the insert does not wait for the commit to be flushed
+// to disk. This makes enqueueing much faster, at the price of losing the
+// jobs enqueued in the last moments before a database crash, which suits
+// e.g. analytics events. Enqueue then inserts the job in a transaction of its
+// own; with EnqueueInTx, or a transaction attached to the context, the
+// setting applies to the whole of that transaction.
+func WithAsyncCommit() EnqueueOption {
+	return func(p *enqueueParams) {
+		p.asyncCommit = true
+	}
+}
+
+// WithOptions returns a copy of j with opts applied to its Queue, Priority
+// and RunAt, describing the job as Enqueue would insert it. It does not copy
+// the database state of a locked job, so the copy cannot be deleted, errored
+// or marked as done.
+func (j *Job) WithOptions(opts ...EnqueueOption) *Job {
+	p := newEnqueueParams(j, TypeOptions{}, opts)
+	cp := &Job{
+		ID:                j.ID,
+		Queue:             j.Queue,
+		Priority:          j.Priority,
+		RunAt:             j.RunAt,
+		Type:              j.Type,
+		Args:              j.Args,
+		Tags:              j.Tags,
+		DependsOn:         j.DependsOn,
+		IdempotencyKey:    j.IdempotencyKey,
+		DebounceKey:       j.DebounceKey,
+		DebounceWindow:    j.DebounceWindow,
+		ExpiresAt:         j.ExpiresAt,
+		Deadline:          j.Deadline,
+		GroupID:           j.GroupID,
+		CompletionWebhook: j.CompletionWebhook,
+		DelayFunction:     j.DelayFunction,
+		ErrorCount:        j.ErrorCount,
+		LastError:         j.LastError,
+	}
+	if p.queue.Status == pgtype.Present {
+		cp.Queue = p.queue.String
+	}
+	if p.priority.Status == pgtype.Present {
+		cp.Priority = p.priority.Int
+	}
+	if p.runAt.Status == pgtype.Present {
+		cp.RunAt = p.runAt.Time
+	}
+	return cp
+}
+
+// TypeOptions are the defaults for the jobs of a type, registered with
+// Client.RegisterType.
+type TypeOptions struct {
+	// Queue is the queue of the jobs of the type that leave Job.Queue empty.
+	// If it is empty too, they go to the Client's DefaultQueue.
+	Queue string
+
+	// Priority is the priority of the jobs of the type that leave
+	// Job.Priority at zero. If it is zero too, they get the default of 100.
+	Priority int16
+
+	// MaxRetries, if positive, is how many times a failed job of the type is
+	// retried. Job.Error deletes a job that fails once more instead of
+	// rescheduling it. The default of zero retries forever.
+	MaxRetries int
+
+	// RetryPriorityStep, if positive, raises the priority of a failed job of
+	// the type by lowering its Priority by that much each time Job.Error
+	// reschedules it, down to 0, so jobs that keep failing are eventually
+	// preferred over newer ones.
+	RetryPriorityStep int16
+}
+
+// RegisterType sets the defaults for the jobs of type jobType that the Client
+// enqueues, so they need not be repeated at every call site. Fields set on a
+// Job take precedence over the defaults, and EnqueueOptions over both.
+// Registering a type again replaces its defaults. RegisterType is not safe
+// for concurrent use with enqueueing, so call it while setting up the Client.
+func (c *Client) RegisterType(jobType string, opts TypeOptions) {
+	if c.Types == nil {
+		c.Types = make(map[string]TypeOptions)
+	}
+	c.Types[jobType] = opts
+}
+
+// SetMaxRetries sets the MaxRetries of the jobs of type jobType that the
+// Client locks, keeping the other TypeOptions of the type. Like RegisterType,
+// call it while setting up the Client.
+func (c *Client) SetMaxRetries(jobType string, n int) {
+	t := c.Types[jobType]
+	t.MaxRetries = n
+	c.RegisterType(jobType, t)
+}
+
+// typeDefaults returns the TypeOptions of jobType, falling back to the
+// DefaultQueue.
+func (c *Client) typeDefaults(jobType string) TypeOptions {
+	t := c.Types[jobType]
+	if t.Queue == "" {
+		t.Queue = c.DefaultQueue
+	}
+	return t
+}
+
+// enqueueParams holds the columns of a job that fall back to the database
+// defaults when they are Null.
+type enqueueParams struct {
+	queue    pgtype.Text
+	priority pgtype.Int2
+	runAt    pgtype.Timestamptz
+
+	asyncCommit bool
+}
+
+// newEnqueueParams returns the parameters of j with opts applied. A job
+// without a Queue or Priority gets the ones of defaults unless opts set them.
+func newEnqueueParams(j *Job, defaults TypeOptions, opts []EnqueueOption) *enqueueParams {
+	p := &enqueueParams{
+		queue:    pgtype.Text{String: j.Queue, Status: pgtype.Null},
+		priority: pgtype.Int2{Int: j.Priority, Status: pgtype.Null},
+		runAt:    pgtype.Timestamptz{Time: j.RunAt, Status: pgtype.Null},
+	}
+	if j.Queue != "" {
+		p.queue.Status = pgtype.Present
+	} else if defaults.Queue != "" {
+		p.queue = pgtype.Text{String: defaults.Queue, Status: pgtype.Present}
+	}
+	if j.Priority != 0 {
+		p.priority.Status = pgtype.Present
+	} else if defaults.Priority != 0 {
+		p.priority = pgtype.Int2{Int: defaults.Priority, Status: pgtype.Present}
 	}
 	if !j.RunAt.IsZero() {
-		runAt.Status = pgtype.Present
+		p.runAt.Status = pgtype.Present
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// enqueueParams checks that j can be enqueued and returns its parameters.
+func (c *Client) enqueueParams(j *Job, opts []EnqueueOption) (*enqueueParams, error) {
+	if j.Type == "" {
+		return nil, ErrMissingType
+	}
+	if c.RequireRegisteredTypes && len(c.Types) != 0 && j.Type != CompletionWebhookType {
+		if _, ok := c.Types[j.Type]; !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnregisteredType, j.Type)
+		}
 	}
 
+	p := newEnqueueParams(j, c.typeDefaults(j.Type), opts)
+	if p.priority.Status == pgtype.Present && p.priority.Int < 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidPriority, p.priority.Int)
+	}
+	return p, nil
+}
+
+// nullTime returns t as a Timestamptz that is Null if t is zero.
+func nullTime(t time.Time) *pgtype.Timestamptz {
+	if t.IsZero() {
+		return &pgtype.Timestamptz{Status: pgtype.Null}
+	}
+	return &pgtype.Timestamptz{Time: t, Status: pgtype.Present}
+}
+
+// nullText returns s as a Text that is Null if s is empty.
+func nullText(s string) *pgtype.Text {
+	if s == "" {
+		return &pgtype.Text{Status: pgtype.Null}
+	}
+	return &pgtype.Text{String: s, Status: pgtype.Present}
+}
+
+// checkArgsSize returns the args to insert for j, which are offloaded with
+// OffloadArgs if they exceed MaxArgsSize.
+func (c *Client) checkArgsSize(j *Job) ([]byte, error) {
+	if c.MaxArgsSize <= 0 || len(j.Args) <= c.MaxArgsSize {
+		return j.Args, nil
+	}
+	if c.OffloadArgs == nil {
+		return nil, fmt.Errorf("%w: %d bytes, limit is %d", ErrArgsTooLarge, len(j.Args), c.MaxArgsSize)
+	}
+	args, err := c.OffloadArgs(j)
+	if err != nil {
+		return nil, fmt.Errorf("offloading args: %w", err)
+	}
+	if len(args) > c.MaxArgsSize {
+		return nil, fmt.Errorf("%w: %d bytes after offloading, limit is %d", ErrArgsTooLarge, len(args), c.MaxArgsSize)
+	}
+	return args, nil
+}
+
+func (c *Client) execEnqueue(ctx context.Context, j *Job, q enqueueQueryer, opts ...EnqueueOption) error {
+	p, err := c.enqueueParams(j, opts)
+	if err != nil {
+		return err
+	}
+
+	debounced := j.DebounceKey != "" && j.DebounceWindow > 0
+	if debounced && p.runAt.Status != pgtype.Present {
+		p.runAt = pgtype.Timestamptz{Time: c.now().Add(j.DebounceWindow), Status: pgtype.Present}
+	}
+
+	if pool, ok := q.(*pgx.ConnPool); ok && (p.asyncCommit || debounced) {
+		// synchronous_commit can only be set, and the debounce lock only
+		// held, for a transaction of our own
+		tx, err := pool.BeginEx(ctx, nil)
+		if err != nil {
+			return &EnqueueError{Queue: p.queue.String, Type: j.Type, Err: err}
+		}
+		defer tx.Rollback()
+
+		if err := c.insertJob(ctx, j, tx, p); err != nil {
+			return err
+		}
+		if err := tx.CommitEx(ctx); err != nil {
+			j.ID = 0
+			return &EnqueueError{Queue: p.queue.String, Type: j.Type, Err: err}
+		}
+		return nil
+	}
+	return c.insertJob(ctx, j, q, p)
+}
+
+// insertJob inserts j with the parameters p using q.
+func (c *Client) insertJob(ctx context.Context, j *Job, q enqueueQueryer, p *enqueueParams) error {
+	argsBytes, err := c.checkArgsSize(j)
+	if err != nil {
+		return err
+	}
 	args := &pgtype.Bytea{
-		Bytes:  j.Args,
+		Bytes:  argsBytes,
 		Status: pgtype.Null,
 	}
-	if len(j.Args) != 0 {
+	if len(argsBytes) != 0 {
 		args.Status = pgtype.Present
 	}
 
-	_, err := q.Exec("que_insert_job", queue, priority, runAt, j.Type, args)
-	return err
+	tags := &pgtype.JSONB{Status: pgtype.Null}
+	if len(j.Tags) != 0 {
+		if err := tags.Set(j.Tags); err != nil {
+			return err
+		}
+	}
+
+	dependsOn := []int64{}
+	if len(j.DependsOn) != 0 {
+		dependsOn = j.DependsOn
+	}
+
+	idempotencyKey := nullText(j.IdempotencyKey)
+	expiresAt := nullTime(j.ExpiresAt)
+	deadline := nullTime(j.Deadline)
+	groupID := nullText(j.GroupID)
+	webhook := nullText(j.CompletionWebhook)
+
+	if p.asyncCommit {
+		var setting string
+		if err := q.QueryRowEx(ctx, "que_async_commit", nil).Scan(&setting); err != nil {
+			return &EnqueueError{Queue: p.queue.String, Type: j.Type, Err: err}
+		}
+	}
+
+	debounceKey := nullText("")
+	if j.DebounceKey != "" && j.DebounceWindow > 0 {
+		debounceKey = nullText(j.DebounceKey)
+		var ok bool
+		if err := q.QueryRowEx(ctx, "que_lock_debounce", nil, j.DebounceKey).Scan(&ok); err != nil {
+			return &EnqueueError{Queue: p.queue.String, Type: j.Type, Err: err}
+		}
+	}
+
+	var row *pgx.Row
+	if c.NotifyChannel == "" {
+		row = q.QueryRowEx(ctx, "que_insert_job", nil, &p.queue, &p.priority, &p.runAt, j.Type, args, tags, dependsOn, idempotencyKey, expiresAt, deadline, groupID, webhook, debounceKey)
+	} else {
+		row = q.QueryRowEx(ctx, "que_insert_job_notify", nil, &p.queue, &p.priority, &p.runAt, j.Type, args, tags, dependsOn, idempotencyKey, expiresAt, deadline, groupID, webhook, debounceKey, c.NotifyChannel)
+	}
+	var id int64
+	if err := row.Scan(&id); err != nil {
+		if err == pgx.ErrNoRows {
+			// a job with the same IdempotencyKey exists, or one with the
+			// same DebounceKey is pending
+			j.ID = 0
+			return nil
+		}
+		var pgErr pgx.PgError
+		if errors.As(err, &pgErr) && pgErr.ConstraintName == "que_jobs_depends_on_earlier" {
+			return fmt.Errorf("%w: %v", ErrInvalidDependency, j.DependsOn)
+		}
+		return &EnqueueError{Queue: p.queue.String, Type: j.Type, Err: err}
+	}
+	j.ID = id
+	return nil
+}
+
+// JobNotification is the payload of the notifications sent on a Client's
+// NotifyChannel.
+type JobNotification struct {
+	Queue string `json:"queue"`
+	ID    int64  `json:"id"`
+}
+
+// ParseJobNotification decodes the payload of a notification received on a
+// Client's NotifyChannel.
+func ParseJobNotification(payload string) (*JobNotification, error) {
+	n := &JobNotification{}
+	if err := json.Unmarshal([]byte(payload), n); err != nil {
+		return nil, fmt.Errorf("parsing job notification: %w", err)
+	}
+	return n, nil
+}
+
+// enqueueQueryer is implemented by *pgx.ConnPool and *pgx.Tx, which execEnqueue
+// inserts jobs with.
+type enqueueQueryer interface {
+	QueryRowEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) *pgx.Row
+}
+
+// txQueryer is implemented by *pgx.Tx, and by a *pgx.Conn in a transaction,
+// which Job.destroy deletes a job with.
+type txQueryer interface {
+	ExecEx(ctx context.Context, sql string, options *pgx.QueryExOptions, arguments ...interface{}) (pgx.CommandTag, error)
+	QueryRowEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) *pgx.Row
 }
 
 type queryable interface {
@@ -227,6 +1422,47 @@ const maxLockJobAttempts = 10
 // concurrency.
 var ErrAgain = errors.New("maximum number of LockJob attempts reached")
 
+// ErrPoolBusy is returned by LockJob if no connection could be acquired from
+// the pool within the Client's AcquireTimeout.
+var ErrPoolBusy = errors.New("timed out acquiring a connection from the pool")
+
+// LockError is returned by LockJob and its variants when locking a job fails
+// with a database error, and tells what was being locked. ErrAgain,
+// ErrPoolBusy and the errors of a done context are returned as they are.
+type LockError struct {
+	// Queue is the queue a job was being locked from, unless ID is set.
+	Queue string
+
+	// ID is the ID of the job being locked by LockJobByID, or zero.
+	ID int64
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *LockError) Error() string {
+	if e.ID != 0 {
+		return fmt.Sprintf("locking job %d: %v", e.ID, e.Err)
+	}
+	return fmt.Sprintf("locking job in queue %q: %v", e.Queue, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *LockError) Unwrap() error {
+	return e.Err
+}
+
+// lockError wraps err, returned while locking a job from queue or the one
+// with ID id, in a *LockError unless it is one of the errors that LockError
+// leaves alone.
+func lockError(queue string, id int64, err error) error {
+	if err == nil || err == ErrAgain || err == ErrPoolBusy ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return &LockError{Queue: queue, ID: id, Err: err}
+}
+
 // TODO: consider an alternate Enqueue func that also returns the newly
 // enqueued Job struct. The query sqlInsertJobAndReturn was already written for
 // this.
@@ -242,30 +1478,225 @@ var ErrAgain = errors.New("maximum number of LockJob attempts reached")
 // After the Job has been worked, you must call either Done() or Error() on it
 // in order to return the database connection to the pool and remove the lock.
 func (c *Client) LockJob(queue string) (*Job, error) {
-	conn, err := c.pool.Acquire()
+	return c.LockJobContext(context.Background(), queue)
+}
+
+// LockJobContext is like LockJob, but stops waiting for a connection and
+// cancels the lock queries once ctx is done. In that case the returned error
+// wraps ctx.Err(), so it can be identified with errors.Is(err,
+// context.Canceled) or errors.Is(err, context.DeadlineExceeded).
+func (c *Client) LockJobContext(ctx context.Context, queue string) (*Job, error) {
+	j, err := c.lockJob(ctx, c.orderedStatement("que_lock_job"), queue)
+	return j, lockError(queue, 0, err)
+}
+
+// The poll interval of Dequeue starts at dequeueMinInterval and doubles after
+// every poll that found no job, up to dequeueMaxInterval.
+const (
+	dequeueMinInterval = 50 * time.Millisecond
+	dequeueMaxInterval = time.Second
+)
+
+// Dequeue is like LockJobContext, but blocks until a job is available in
+// queue instead of returning nil, polling with a growing interval of up to a
+// second. It returns an error wrapping ctx.Err() once ctx is done, and is
+// meant for consumers that work jobs themselves rather than with a Worker:
+// the returned Job must be handled with Delete or Error and then Done, like
+// one from LockJob.
+func (c *Client) Dequeue(ctx context.Context, queue string) (*Job, error) {
+	interval := dequeueMinInterval
+	for {
+		j, err := c.LockJobContext(ctx, queue)
+		if err != nil && err != ErrAgain {
+			return nil, err
+		}
+		if j != nil {
+			return j, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, lockContextError(ctx.Err())
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > dequeueMaxInterval {
+			interval = dequeueMaxInterval
+		}
+	}
+}
+
+// LockAnyJob is like LockJobContext, but locks the first job that is ready in
+// any queue that is not paused, so the most urgent job is worked whatever its
+// queue. Across queues, jobs are ordered by the Client's LockOrder using the
+// que_jobs_priority_run_at_idx index of schema.sql, which the lock queries of
+// a single queue do not need.
+func (c *Client) LockAnyJob(ctx context.Context) (*Job, error) {
+	j, err := c.lockJob(ctx, c.orderedStatement("que_lock_any_job"))
+	return j, lockError("", 0, err)
+}
+
+// LockJobByID locks the job with the given ID, for instance to rework a job
+// that kept failing once the bug is fixed. Its RunAt, dependencies and queue
+// are ignored, so a job waiting for its retry is locked right away. It
+// returns nil and no error if there is no such job or it is locked already.
+// The returned Job is handled like one from LockJob.
+func (c *Client) LockJobByID(ctx context.Context, id int64) (*Job, error) {
+	j, err := c.lockJob(ctx, "que_lock_job_by_id", id)
+	return j, lockError("", id, err)
+}
+
+// LockJobMatching is like LockJobContext, but only locks a job whose Tags
+// contain every key/value pair in tags. An empty tags matches any job.
+func (c *Client) LockJobMatching(ctx context.Context, queue string, tags map[string]string) (*Job, error) {
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	filter, err := json.Marshal(tags)
 	if err != nil {
 		return nil, err
 	}
+	j, err := c.lockJob(ctx, c.orderedStatement("que_lock_job_matching"), queue, string(filter))
+	return j, lockError(queue, 0, err)
+}
+
+// orderedStatement returns the variant of the prepared lock or peek
+// statement stmt that orders jobs by the Client's LockOrder.
+func (c *Client) orderedStatement(stmt string) string {
+	switch c.LockOrder {
+	case LockOrderFIFO:
+		return stmt + "_fifo"
+	case LockOrderDeadline:
+		return stmt + "_deadline"
+	}
+	return stmt
+}
 
-	j := Job{pool: c.pool, conn: conn, delayFunction: DelayFunction}
+// now returns the time according to the Client's Clock.
+func (c *Client) now() time.Time {
+	if c.Clock == nil {
+		return time.Now()
+	}
+	return c.Clock.Now()
+}
 
-	for i := 0; i < maxLockJobAttempts; i++ {
-		err = conn.QueryRow("que_lock_job", queue).Scan(
-			&j.Queue,
-			&j.Priority,
-			&j.RunAt,
-			&j.ID,
-			&j.Type,
-			&j.Args,
-			&j.ErrorCount,
-		)
+// locker returns the Client's Locker, or an AdvisoryLocker if it has none.
+func (c *Client) locker() Locker {
+	if c.Locker == nil {
+		return AdvisoryLocker{}
+	}
+	return c.Locker
+}
+
+// lockJob locks a job using the prepared lock statement stmt, which takes args
+// followed by the Client's AdvisoryLockClass.
+func (c *Client) lockJob(ctx context.Context, stmt string, args ...interface{}) (*Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, lockContextError(err)
+	}
+
+	conn, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := c.lockJobOnConn(ctx, conn, stmt, args...)
+	if j == nil {
+		c.pool.Release(conn)
+		return nil, err
+	}
+	j.pool = c.pool
+	return j, nil
+}
+
+// LockJobs locks up to n jobs from queue on a single connection, in the order
+// LockJob would lock them one by one, so that a WorkFunc can process a batch
+// of jobs at once. It returns no jobs and a nil error if none are ready.
+//
+// All the returned jobs share one connection, which is returned to the pool
+// once Done has been called on every one of them, for instance with DoneAll.
+// Because a connection cannot run several queries at once, the jobs must not
+// be deleted, errored or otherwise used from different goroutines at the same
+// time.
+func (c *Client) LockJobs(ctx context.Context, queue string, n int) ([]*Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, lockContextError(err)
+	}
+	if n < 1 {
+		return nil, nil
+	}
+
+	conn, err := c.acquire(ctx)
+	if err != nil {
+		return nil, lockError(queue, 0, err)
+	}
+
+	var jobs []*Job
+	ids := []int64{}
+	for len(jobs) < n {
+		j, err := c.lockJobOnConn(ctx, conn, c.orderedStatement("que_lock_job_excluding"), queue, ids)
 		if err != nil {
+			DoneAll(jobs)
 			c.pool.Release(conn)
+			return nil, lockError(queue, 0, err)
+		}
+		if j == nil {
+			break
+		}
+		jobs = append(jobs, j)
+		ids = append(ids, j.ID)
+	}
+	if len(jobs) == 0 {
+		c.pool.Release(conn)
+		return nil, nil
+	}
+
+	b := &lockBatch{pool: c.pool, conn: conn, pending: len(jobs)}
+	for _, j := range jobs {
+		j.batch = b
+	}
+	return jobs, nil
+}
+
+// lockJobOnConn locks a job on a connection that the caller has already
+// acquired and remains responsible for releasing. The returned Job has no
+// pool, so its Done only removes the lock. stmt and args are as for lockJob.
+func (c *Client) lockJobOnConn(ctx context.Context, conn *pgx.Conn, stmt string, args ...interface{}) (*Job, error) {
+	j := Job{
+		conn:             conn,
+		argsCodec:        c.ArgsCodec,
+		delayFunction:    DelayFunction,
+		lockClass:        c.AdvisoryLockClass,
+		locker:           c.locker(),
+		clock:            c.Clock,
+		onGroupDone:      c.OnGroupDone,
+		statementTimeout: c.StatementTimeout,
+		maxErrorLength:   c.MaxErrorLength,
+	}
+
+	if c.StatementTimeout > 0 {
+		if err := setStatementTimeout(conn, c.StatementTimeout); err != nil {
+			if ctx.Err() != nil {
+				return nil, lockContextError(ctx.Err())
+			}
+			return nil, err
+		}
+		defer resetStatementTimeout(conn)
+	}
+
+	params := append(args[:len(args):len(args)], c.AdvisoryLockClass)
+
+	for i := 0; i < maxLockJobAttempts; i++ {
+		err := j.locker.Lock(ctx, conn, stmt, &j, params...)
+		if err != nil {
 			if err == pgx.ErrNoRows {
 				return nil, nil
 			}
-			return nil, err
+			if ctx.Err() != nil {
+				return nil, lockContextError(ctx.Err())
+			}
+			return nil, statementTimeoutError(err)
 		}
+		j.maxRetries = c.Types[j.Type].MaxRetries
+		j.priorityStep = c.Types[j.Type].RetryPriorityStep
 
 		// Deal with race condition. Explanation from the Ruby Que gem:
 		//
@@ -279,35 +1710,227 @@ func (c *Client) LockJob(queue string) (*Job, error) {
 		// Note that there is currently no spec for this behavior, since
 		// I'm not sure how to reliably commit a transaction that deletes
 		// the job in a separate thread between lock_job and check_job.
-		var ok bool
-		err = conn.QueryRow("que_check_job", j.Queue, j.Priority, j.RunAt, j.ID).Scan(&ok)
-		if err == nil {
+		if c.DisableRaceCheck {
+			j.ctx = newJobContext(ctx, &j)
+			return &j, nil
+		}
+		ok, err := j.locker.Check(ctx, conn, &j)
+		if ok {
+			j.ctx = newJobContext(ctx, &j)
 			return &j, nil
-		} else if err == pgx.ErrNoRows {
+		} else if err == nil {
 			// Encountered job race condition; start over from the beginning.
 			// We're still holding the advisory lock, though, so we need to
 			// release it before resuming.  Otherwise we leak the lock,
 			// eventually causing the server to run out of locks.
 			//
 			// Also swallow the possible error, exactly like in Done.
-			_ = conn.QueryRow("que_unlock_job", j.ID).Scan(&ok)
+			_ = j.locker.Unlock(conn, &j)
+			if c.OnLockRace != nil {
+				c.OnLockRace(i + 1)
+			}
 			continue
 		} else {
-			c.pool.Release(conn)
-			return nil, err
+			// The advisory lock may already be held if only check_job was
+			// cancelled, so release it before handing back the connection.
+			_ = j.locker.Unlock(conn, &j)
+			if ctx.Err() != nil {
+				return nil, lockContextError(ctx.Err())
+			}
+			return nil, statementTimeoutError(err)
 		}
 	}
-	c.pool.Release(conn)
 	return nil, ErrAgain
 }
 
+// ErrStatementTimeout is wrapped by the errors returned when a query is
+// cancelled by the Client's StatementTimeout.
+var ErrStatementTimeout = errors.New("statement timeout exceeded")
+
+// setStatementTimeout sets the statement_timeout of the session on conn.
+func setStatementTimeout(conn *pgx.Conn, d time.Duration) error {
+	ms := d.Milliseconds()
+	if ms < 1 {
+		// zero would disable the timeout
+		ms = 1
+	}
+	_, err := conn.Exec("SELECT set_config('statement_timeout', $1, false)", fmt.Sprintf("%dms", ms))
+	return err
+}
+
+// resetStatementTimeout restores the session default statement_timeout on
+// conn. Its error is swallowed like the unlock errors in Done.
+func resetStatementTimeout(conn *pgx.Conn) {
+	_, _ = conn.Exec("RESET statement_timeout")
+}
+
+// statementTimeoutError wraps err in ErrStatementTimeout if Postgres
+// cancelled the query, which it does once statement_timeout is exceeded.
+func statementTimeoutError(err error) error {
+	var pgErr pgx.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "57014" {
+		return fmt.Errorf("%w: %v", ErrStatementTimeout, err)
+	}
+	return err
+}
+
+// rowScanner is implemented by *pgx.Row and *pgx.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanJob reads the sqlJobColumns of row into j, followed by any extra
+// columns into extra.
+func scanJob(row rowScanner, j *Job, extra ...interface{}) error {
+	var expiresAt, deadline pgtype.Timestamptz
+	var groupID, webhook pgtype.Text
+	dest := append([]interface{}{
+		&j.Queue,
+		&j.Priority,
+		&j.RunAt,
+		&j.ID,
+		&j.Type,
+		&j.Args,
+		&j.ErrorCount,
+		&j.LastError,
+		&j.Tags,
+		&expiresAt,
+		&deadline,
+		&groupID,
+		&webhook,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+	j.ExpiresAt = time.Time{}
+	if expiresAt.Status == pgtype.Present {
+		j.ExpiresAt = expiresAt.Time
+	}
+	j.Deadline = time.Time{}
+	if deadline.Status == pgtype.Present {
+		j.Deadline = deadline.Time
+	}
+	j.GroupID = groupID.String
+	j.CompletionWebhook = webhook.String
+	return nil
+}
+
+// acquire takes a connection from the pool, giving up after the Client's
+// AcquireTimeout or once ctx is done.
+func (c *Client) acquire(ctx context.Context) (*pgx.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, lockContextError(err)
+	}
+	acquireCtx := ctx
+	if c.AcquireTimeout > 0 {
+		var cancel context.CancelFunc
+		acquireCtx, cancel = context.WithTimeout(ctx, c.AcquireTimeout)
+		defer cancel()
+	}
+
+	// AcquireEx only honours the deadline of its context, so wait for the
+	// connection in a goroutine to give up as soon as ctx is canceled.
+	type acquired struct {
+		conn *pgx.Conn
+		err  error
+	}
+	ch := make(chan acquired, 1)
+	go func() {
+		conn, err := c.pool.AcquireEx(acquireCtx)
+		ch <- acquired{conn, err}
+	}()
+
+	select {
+	case a := <-ch:
+		if a.err != nil {
+			if ctx.Err() != nil {
+				return nil, lockContextError(ctx.Err())
+			}
+			if a.err == pgx.ErrAcquireTimeout && c.AcquireTimeout > 0 {
+				return nil, ErrPoolBusy
+			}
+			return nil, a.err
+		}
+		return a.conn, nil
+	case <-ctx.Done():
+		// give back the connection if it arrives after all
+		go func() {
+			if a := <-ch; a.err == nil {
+				c.pool.Release(a.conn)
+			}
+		}()
+		return nil, lockContextError(ctx.Err())
+	}
+}
+
+// lockContextError wraps the error of a done context so that callers of
+// LockJobContext can tell it apart from database errors.
+func lockContextError(err error) error {
+	return fmt.Errorf("locking job: %w", err)
+}
+
 var preparedStatements = map[string]string{
-	"que_check_job":   sqlCheckJob,
-	"que_destroy_job": sqlDeleteJob,
-	"que_insert_job":  sqlInsertJob,
-	"que_lock_job":    sqlLockJob,
-	"que_set_error":   sqlSetError,
-	"que_unlock_job":  sqlUnlockJob,
+	"que_advisory_lock_count":                     sqlAdvisoryLockCount,
+	"que_age_priorities":                          sqlAgePriorities,
+	"que_async_commit":                            sqlAsyncCommit,
+	"que_check_job":                               sqlCheckJob,
+	"que_count_by_type":                           sqlCountByType,
+	"que_count_jobs":                              sqlCountJobs,
+	"que_delete_expired":                          sqlDeleteExpired,
+	"que_delete_older_than":                       sqlDeleteOlderThan,
+	"que_destroy_job":                             sqlDeleteJob,
+	"que_get_job":                                 sqlGetJob,
+	"que_group_remaining":                         sqlGroupRemaining,
+	"que_insert_job":                              sqlInsertJob,
+	"que_insert_job_notify":                       sqlInsertJobNotify,
+	"que_job_exists":                              sqlJobExists,
+	"que_list_jobs":                               sqlListJobs,
+	"que_lock_any_job":                            sqlLockAnyJob,
+	"que_lock_any_job_deadline":                   sqlLockAnyJobDeadline,
+	"que_lock_any_job_deadline_skip_locked":       sqlLockAnyJobDeadlineSkipLocked,
+	"que_lock_any_job_fifo":                       sqlLockAnyJobFIFO,
+	"que_lock_any_job_fifo_skip_locked":           sqlLockAnyJobFIFOSkipLocked,
+	"que_lock_any_job_skip_locked":                sqlLockAnyJobSkipLocked,
+	"que_lock_debounce":                           sqlLockDebounce,
+	"que_lock_group":                              sqlLockGroup,
+	"que_lock_holder":                             sqlLockHolder,
+	"que_lock_job":                                sqlLockJob,
+	"que_lock_job_by_id":                          sqlLockJobByID,
+	"que_lock_job_by_id_skip_locked":              sqlLockJobByIDSkipLocked,
+	"que_lock_job_deadline":                       sqlLockJobDeadline,
+	"que_lock_job_deadline_skip_locked":           sqlLockJobDeadlineSkipLocked,
+	"que_lock_job_excluding":                      sqlLockJobExcluding,
+	"que_lock_job_excluding_deadline":             sqlLockJobExcludingDeadline,
+	"que_lock_job_excluding_deadline_skip_locked": sqlLockJobExcludingDeadlineSkipLocked,
+	"que_lock_job_excluding_fifo":                 sqlLockJobExcludingFIFO,
+	"que_lock_job_excluding_fifo_skip_locked":     sqlLockJobExcludingFIFOSkipLocked,
+	"que_lock_job_excluding_skip_locked":          sqlLockJobExcludingSkipLocked,
+	"que_lock_job_fifo":                           sqlLockJobFIFO,
+	"que_lock_job_fifo_skip_locked":               sqlLockJobFIFOSkipLocked,
+	"que_lock_job_matching":                       sqlLockJobMatching,
+	"que_lock_job_matching_deadline":              sqlLockJobMatchingDeadline,
+	"que_lock_job_matching_deadline_skip_locked":  sqlLockJobMatchingDeadlineSkipLocked,
+	"que_lock_job_matching_fifo":                  sqlLockJobMatchingFIFO,
+	"que_lock_job_matching_fifo_skip_locked":      sqlLockJobMatchingFIFOSkipLocked,
+	"que_lock_job_matching_skip_locked":           sqlLockJobMatchingSkipLocked,
+	"que_lock_job_skip_locked":                    sqlLockJobSkipLocked,
+	"que_move_queue":                              sqlMoveQueue,
+	"que_one_queue_stats":                         sqlOneQueueStats,
+	"que_pause_queue":                             sqlPauseQueue,
+	"que_peek_job":                                sqlPeekJob,
+	"que_peek_job_deadline":                       sqlPeekJobDeadline,
+	"que_peek_job_fifo":                           sqlPeekJobFIFO,
+	"que_purge_by_type":                           sqlPurgeByType,
+	"que_queue_stats":                             sqlQueueStats,
+	"que_reschedule_job":                          sqlRescheduleJob,
+	"que_resume_queue":                            sqlResumeQueue,
+	"que_scheduled_within":                        sqlScheduledWithin,
+	"que_set_error":                               sqlSetError,
+	"que_set_locked_by":                           sqlSetLockedBy,
+	"que_set_priority":                            sqlSetPriority,
+	"que_set_worked_by_labels":                    sqlSetWorkedByLabels,
+	"que_unlock_job":                              sqlUnlockJob,
+	"que_update_args":                             sqlUpdateArgs,
 }
 
 func PrepareStatements(conn *pgx.Conn) error {
@@ -29,28 +29,17 @@ func openTestClient(t testing.TB) *Client {
 }
 
 func truncateAndClose(pool *pgx.ConnPool) {
-	if _, err := pool.Exec("TRUNCATE TABLE que_jobs"); err != nil {
+	if _, err := pool.Exec("TRUNCATE TABLE que_jobs, que_queue_state"); err != nil {
 		panic(err)
 	}
 	pool.Close()
 }
 
 func findOneJob(q queryable) (*Job, error) {
-	findSQL := `
-	SELECT priority, run_at, job_id, job_class, args, error_count, last_error, queue
-	FROM que_jobs LIMIT 1`
+	findSQL := `SELECT ` + sqlJobColumns + ` FROM que_jobs LIMIT 1`
 
 	j := &Job{}
-	err := q.QueryRow(findSQL).Scan(
-		&j.Priority,
-		&j.RunAt,
-		&j.ID,
-		&j.Type,
-		&j.Args,
-		&j.ErrorCount,
-		&j.LastError,
-		&j.Queue,
-	)
+	err := scanJob(q.QueryRow(findSQL), j)
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
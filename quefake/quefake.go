@@ -0,0 +1,142 @@
+// Package quefake provides an in-memory stand-in for a que.Client, so code
+// that enqueues jobs and the WorkFuncs that handle them can be unit tested
+// without a database.
+//
+//	c := quefake.NewClient()
+//	if err := signup(c, "alice"); err != nil { // takes a que.Enqueuer
+//	    t.Fatal(err)
+//	}
+//	if jobs := c.JobsOfType("SendWelcomeEmail"); len(jobs) != 1 {
+//	    t.Fatalf("want 1 SendWelcomeEmail job, got %d", len(jobs))
+//	}
+//	if err := c.WorkAll(workMap); err != nil {
+//	    t.Fatal(err)
+//	}
+package quefake
+
+import (
+	"fmt"
+	"sync"
+
+	que "github.com/bgentry/que-go"
+)
+
+var _ que.Enqueuer = (*Client)(nil)
+
+// Client records enqueued jobs in memory. It is safe for concurrent use.
+type Client struct {
+	mu     sync.Mutex
+	nextID int64
+	jobs   []*que.Job
+}
+
+// NewClient returns an empty Client.
+func NewClient() *Client {
+	return &Client{}
+}
+
+// Enqueue records a copy of j with opts applied and sets the ID of both. Like
+// que.Client it returns que.ErrMissingType for a job without a Type and
+// que.ErrInvalidPriority for a negative priority, and it ignores a job whose
+// IdempotencyKey matches one that has not been worked yet.
+func (c *Client) Enqueue(j *que.Job, opts ...que.EnqueueOption) error {
+	if j.Type == "" {
+		return que.ErrMissingType
+	}
+	rec := j.WithOptions(opts...)
+	if rec.Priority < 0 {
+		return fmt.Errorf("%w: %d", que.ErrInvalidPriority, rec.Priority)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if rec.IdempotencyKey != "" {
+		for _, other := range c.jobs {
+			if other.IdempotencyKey == rec.IdempotencyKey {
+				j.ID = 0
+				return nil
+			}
+		}
+	}
+
+	c.nextID++
+	rec.ID = c.nextID
+	j.ID = c.nextID
+	c.jobs = append(c.jobs, rec)
+	return nil
+}
+
+// Jobs returns the jobs that have been enqueued and not yet worked, in the
+// order they were enqueued.
+func (c *Client) Jobs() []*que.Job {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]*que.Job(nil), c.jobs...)
+}
+
+// JobsOfType returns the jobs of type jobType that have been enqueued and not
+// yet worked, in the order they were enqueued.
+func (c *Client) JobsOfType(jobType string) []*que.Job {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var jobs []*que.Job
+	for _, j := range c.jobs {
+		if j.Type == jobType {
+			jobs = append(jobs, j)
+		}
+	}
+	return jobs
+}
+
+// Reset forgets all enqueued jobs.
+func (c *Client) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.jobs = nil
+}
+
+// WorkAll runs the WorkFunc from m for each enqueued job in the order they
+// were enqueued, including jobs enqueued by the WorkFuncs themselves, until
+// none are left. Jobs are run regardless of their Queue and RunAt. It stops at
+// the first job that has no WorkFunc in m or whose WorkFunc returns an error,
+// leaving that job and the ones after it enqueued.
+//
+// The jobs handed to the WorkFuncs are not locked in a database, so calling
+// Conn on them returns nil and their Delete and Error methods return
+// que.ErrJobNotLocked.
+func (c *Client) WorkAll(m que.WorkMap) error {
+	for {
+		c.mu.Lock()
+		if len(c.jobs) == 0 {
+			c.mu.Unlock()
+			return nil
+		}
+		j := c.jobs[0]
+		c.mu.Unlock()
+
+		wf, ok := m[j.Type]
+		if !ok {
+			return fmt.Errorf("%w: %q", que.ErrUnknownType, j.Type)
+		}
+		if err := wf(j); err != nil {
+			return fmt.Errorf("working job %d of type %q: %w", j.ID, j.Type, err)
+		}
+
+		c.mu.Lock()
+		c.remove(j)
+		c.mu.Unlock()
+	}
+}
+
+func (c *Client) remove(j *que.Job) {
+	for i, other := range c.jobs {
+		if other == j {
+			c.jobs = append(c.jobs[:i], c.jobs[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package quefake
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	que "github.com/bgentry/que-go"
+)
+
+func TestEnqueue(t *testing.T) {
+	c := NewClient()
+
+	if err := c.Enqueue(&que.Job{}); err != que.ErrMissingType {
+		t.Fatalf("want ErrMissingType, got %v", err)
+	}
+
+	var e que.Enqueuer = c
+	if err := e.Enqueue(&que.Job{Type: "A", Priority: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Enqueue(&que.Job{Type: "B"}, que.WithQueue("low"), que.WithPriority(200)); err != nil {
+		t.Fatal(err)
+	}
+	last := &que.Job{Type: "A"}
+	if err := e.Enqueue(last); err != nil {
+		t.Fatal(err)
+	}
+	if last.ID != 3 {
+		t.Errorf("want the enqueued job's ID set to 3, got %d", last.ID)
+	}
+
+	jobs := c.Jobs()
+	if len(jobs) != 3 {
+		t.Fatalf("want 3 jobs, got %d", len(jobs))
+	}
+	for i, j := range jobs {
+		if want := int64(i + 1); j.ID != want {
+			t.Errorf("want job %d to have ID=%d, got %d", i, want, j.ID)
+		}
+	}
+	if b := jobs[1]; b.Queue != "low" || b.Priority != 200 {
+		t.Errorf("want options applied to job B, got Queue=%q Priority=%d", b.Queue, b.Priority)
+	}
+	if n := len(c.JobsOfType("A")); n != 2 {
+		t.Errorf("want 2 jobs of type A, got %d", n)
+	}
+
+	dup := &que.Job{Type: "A", IdempotencyKey: "k"}
+	for i := 0; i < 2; i++ {
+		if err := e.Enqueue(dup); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dup.ID != 0 || len(c.Jobs()) != 4 {
+		t.Errorf("want the duplicate skipped, got ID=%d and %d jobs", dup.ID, len(c.Jobs()))
+	}
+
+	c.Reset()
+	if n := len(c.Jobs()); n != 0 {
+		t.Errorf("want no jobs after Reset, got %d", n)
+	}
+}
+
+func TestWorkAll(t *testing.T) {
+	c := NewClient()
+
+	var worked []string
+	m := que.WorkMap{
+		"Parent": func(j *que.Job) error {
+			worked = append(worked, j.Type)
+			return c.Enqueue(&que.Job{Type: "Child"})
+		},
+		"Child": func(j *que.Job) error {
+			worked = append(worked, j.Type)
+			return nil
+		},
+	}
+
+	if err := c.Enqueue(&que.Job{Type: "Parent"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WorkAll(m); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(worked, ","); got != "Parent,Child" {
+		t.Errorf("want Parent,Child worked, got %s", got)
+	}
+	if n := len(c.Jobs()); n != 0 {
+		t.Errorf("want no jobs left, got %d", n)
+	}
+}
+
+func TestWorkAllStopsOnError(t *testing.T) {
+	c := NewClient()
+
+	boom := errors.New("boom")
+	m := que.WorkMap{
+		"Failing": func(j *que.Job) error { return boom },
+	}
+	for _, typ := range []string{"Failing", "Unknown"} {
+		if err := c.Enqueue(&que.Job{Type: typ}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := c.WorkAll(m)
+	if !errors.Is(err, boom) {
+		t.Fatalf("want the WorkFunc's error, got %v", err)
+	}
+	if n := len(c.Jobs()); n != 2 {
+		t.Errorf("want both jobs left, got %d", n)
+	}
+
+	c.Reset()
+	if err = c.Enqueue(&que.Job{Type: "Unknown"}); err != nil {
+		t.Fatal(err)
+	}
+	err = c.WorkAll(m)
+	if !errors.Is(err, que.ErrUnknownType) {
+		t.Fatalf("want ErrUnknownType, got %v", err)
+	}
+}
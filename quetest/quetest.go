@@ -0,0 +1,107 @@
+// Package quetest provides helpers for integration tests of applications that
+// run handlers against a real Postgres database with the que schema loaded.
+//
+//	c, pool := quetest.NewTestClient(t, "postgres://localhost/myapp-test")
+//	if err := c.Enqueue(&que.Job{Type: "MyJob"}); err != nil {
+//	    t.Fatal(err)
+//	}
+//	j, err := quetest.FindOne(pool)
+package quetest
+
+import (
+	"testing"
+
+	que "github.com/bgentry/que-go"
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+// NewTestClient connects to the database at connString, which can be a URI
+// or a DSN, and returns a Client and its pool with que's prepared statements
+// loaded. The tables are truncated and the pool is closed when the test
+// finishes. It fails the test if the database cannot be reached.
+func NewTestClient(t testing.TB, connString string) (*que.Client, *pgx.ConnPool) {
+	t.Helper()
+
+	connConfig, err := pgx.ParseConnectionString(connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := pgx.NewConnPool(pgx.ConnPoolConfig{
+		ConnConfig:     connConfig,
+		MaxConnections: 5,
+		AfterConnect:   que.PrepareStatements,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := Truncate(pool); err != nil {
+			t.Error(err)
+		}
+		pool.Close()
+	})
+	return que.NewClient(pool), pool
+}
+
+// Truncate deletes all jobs and queue states.
+func Truncate(pool *pgx.ConnPool) error {
+	_, err := pool.Exec("TRUNCATE TABLE que_jobs, que_queue_state")
+	return err
+}
+
+// Queryer is implemented by *pgx.ConnPool, *pgx.Conn and *pgx.Tx.
+type Queryer interface {
+	QueryRow(sql string, args ...interface{}) *pgx.Row
+}
+
+// FindOne returns an arbitrary job, or nil if there are none. Pass a *pgx.Tx
+// to see jobs enqueued in a transaction that is not committed yet. The
+// returned Job is a snapshot that cannot be deleted, errored or marked as
+// done. It has all the fields of the Job that are stored with it; a
+// DebounceWindow is not stored.
+func FindOne(q Queryer) (*que.Job, error) {
+	findSQL := `
+	SELECT priority, run_at, job_id, job_class, args, error_count, last_error, queue, tags, depends_on,
+	       idempotency_key, expires_at, deadline, group_id, completion_webhook, debounce_key
+	FROM que_jobs LIMIT 1`
+
+	j := &que.Job{}
+	var idempotencyKey, groupID, webhook, debounceKey pgtype.Text
+	var expiresAt, deadline pgtype.Timestamptz
+	err := q.QueryRow(findSQL).Scan(
+		&j.Priority,
+		&j.RunAt,
+		&j.ID,
+		&j.Type,
+		&j.Args,
+		&j.ErrorCount,
+		&j.LastError,
+		&j.Queue,
+		&j.Tags,
+		&j.DependsOn,
+		&idempotencyKey,
+		&expiresAt,
+		&deadline,
+		&groupID,
+		&webhook,
+		&debounceKey,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	j.IdempotencyKey = idempotencyKey.String
+	if expiresAt.Status == pgtype.Present {
+		j.ExpiresAt = expiresAt.Time
+	}
+	if deadline.Status == pgtype.Present {
+		j.Deadline = deadline.Time
+	}
+	j.GroupID = groupID.String
+	j.CompletionWebhook = webhook.String
+	j.DebounceKey = debounceKey.String
+	return j, nil
+}
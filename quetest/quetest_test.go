@@ -0,0 +1,41 @@
+package quetest
+
+import (
+	"testing"
+
+	que "github.com/bgentry/que-go"
+)
+
+const testConnString = "host=localhost database=que-go-test"
+
+func TestNewTestClient(t *testing.T) {
+	c, pool := NewTestClient(t, testConnString)
+
+	j, err := FindOne(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j != nil {
+		t.Fatalf("want no job, got %+v", j)
+	}
+
+	if err = c.Enqueue(&que.Job{Type: "MyJob", Priority: 5, IdempotencyKey: "key", GroupID: "group"}); err != nil {
+		t.Fatal(err)
+	}
+	if j, err = FindOne(pool); err != nil {
+		t.Fatal(err)
+	}
+	if j == nil || j.Type != "MyJob" || j.Priority != 5 {
+		t.Fatalf("want the enqueued job, got %+v", j)
+	}
+	if j.IdempotencyKey != "key" || j.GroupID != "group" || !j.ExpiresAt.IsZero() {
+		t.Fatalf("want IdempotencyKey, GroupID and no ExpiresAt, got %+v", j)
+	}
+
+	if err = Truncate(pool); err != nil {
+		t.Fatal(err)
+	}
+	if j, err = FindOne(pool); err != nil || j != nil {
+		t.Fatalf("want no job after Truncate, got %+v (err %v)", j, err)
+	}
+}
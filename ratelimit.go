@@ -0,0 +1,74 @@
+package que
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket that limits how often the jobs of a type are
+// worked, see Worker.RateLimits. Share one RateLimiter between Workers to
+// limit them together, as a WorkerPool does. It is safe for concurrent use.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter that allows perSecond jobs per second
+// on average, and bursts of up to burst jobs. A burst below 1 is taken as 1,
+// and a perSecond of zero or less does not limit at all.
+func NewRateLimiter(perSecond float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: perSecond, burst: float64(burst), tokens: float64(burst)}
+}
+
+// reserve takes a token at now and returns how long to wait before using it.
+// If the wait would exceed maxWait, when positive, no token is taken and ok
+// is false.
+func (l *RateLimiter) reserve(now time.Time, maxWait time.Duration) (wait time.Duration, ok bool) {
+	if l.rate <= 0 {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.last.IsZero() && now.After(l.last) {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	if now.After(l.last) {
+		l.last = now
+	}
+
+	if l.tokens < 1 {
+		wait = time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		if maxWait > 0 && wait > maxWait {
+			return wait, false
+		}
+	}
+	l.tokens--
+	return wait, true
+}
+
+// cancel gives back the token of a reservation that was not used, such as
+// one whose wait was cut short.
+func (l *RateLimiter) cancel() {
+	if l.rate <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens++
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
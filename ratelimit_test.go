@@ -0,0 +1,64 @@
+package que
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := NewRateLimiter(10, 2)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if wait, ok := l.reserve(now, 0); !ok || wait != 0 {
+			t.Fatalf("want the burst let through right away, got wait=%s ok=%v", wait, ok)
+		}
+	}
+	if wait, ok := l.reserve(now, 0); !ok || wait != 100*time.Millisecond {
+		t.Errorf("want a wait of 100ms once the burst is used, got wait=%s ok=%v", wait, ok)
+	}
+	if wait, ok := l.reserve(now, 150*time.Millisecond); ok || wait != 200*time.Millisecond {
+		t.Errorf("want a wait of 200ms over maxWait refused, got wait=%s ok=%v", wait, ok)
+	}
+
+	// a refused reservation takes no token
+	if wait, ok := l.reserve(now.Add(200*time.Millisecond), 0); !ok || wait != 0 {
+		t.Errorf("want a token after 200ms, got wait=%s ok=%v", wait, ok)
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	l := NewRateLimiter(10, 1)
+	now := time.Now()
+
+	if _, ok := l.reserve(now, 0); !ok {
+		t.Fatal("want the burst let through")
+	}
+	if wait, ok := l.reserve(now, 0); !ok || wait != 100*time.Millisecond {
+		t.Fatalf("want a wait of 100ms, got wait=%s ok=%v", wait, ok)
+	}
+	l.cancel()
+	if wait, ok := l.reserve(now, 0); !ok || wait != 100*time.Millisecond {
+		t.Errorf("want the canceled reservation given back, got wait=%s ok=%v", wait, ok)
+	}
+
+	// tokens given back never exceed the burst
+	l.cancel()
+	l.cancel()
+	l.cancel()
+	if _, ok := l.reserve(now, 0); !ok {
+		t.Fatal("want a token")
+	}
+	if wait, ok := l.reserve(now, 0); !ok || wait != 100*time.Millisecond {
+		t.Errorf("want a single token after canceling, got wait=%s ok=%v", wait, ok)
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	l := NewRateLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		if wait, ok := l.reserve(time.Now(), time.Millisecond); !ok || wait != 0 {
+			t.Fatalf("want no limit with a rate of 0, got wait=%s ok=%v", wait, ok)
+		}
+	}
+}
@@ -8,8 +8,58 @@ CREATE TABLE IF NOT EXISTS que_jobs
   error_count integer     NOT NULL DEFAULT 0,
   last_error  text,
   queue       text        NOT NULL DEFAULT '',
+  tags        jsonb       NOT NULL DEFAULT '{}'::jsonb,
+  locked_by   text,
+  depends_on  bigint[]    NOT NULL DEFAULT '{}',
+  idempotency_key text,
+  expires_at  timestamptz,
+  deadline    timestamptz,
+  group_id    text,
+  completion_webhook text,
+  debounce_key text,
+  worked_by_labels jsonb,
 
-  CONSTRAINT que_jobs_pkey PRIMARY KEY (queue, priority, run_at, job_id)
+  CONSTRAINT que_jobs_pkey PRIMARY KEY (queue, priority, run_at, job_id),
+  CONSTRAINT que_jobs_depends_on_earlier CHECK (job_id > ALL (depends_on))
 );
 
-COMMENT ON TABLE que_jobs IS '3';
+-- Bring a que_jobs table created by an earlier version up to date. These
+-- statements do nothing on a table created above.
+ALTER TABLE que_jobs
+  ADD COLUMN IF NOT EXISTS tags        jsonb    NOT NULL DEFAULT '{}'::jsonb,
+  ADD COLUMN IF NOT EXISTS locked_by   text,
+  ADD COLUMN IF NOT EXISTS depends_on  bigint[] NOT NULL DEFAULT '{}',
+  ADD COLUMN IF NOT EXISTS idempotency_key text,
+  ADD COLUMN IF NOT EXISTS expires_at  timestamptz,
+  ADD COLUMN IF NOT EXISTS deadline    timestamptz,
+  ADD COLUMN IF NOT EXISTS group_id    text,
+  ADD COLUMN IF NOT EXISTS completion_webhook text,
+  ADD COLUMN IF NOT EXISTS debounce_key text,
+  ADD COLUMN IF NOT EXISTS worked_by_labels jsonb;
+
+DO $$
+BEGIN
+  IF NOT EXISTS (
+    SELECT 1 FROM pg_constraint
+    WHERE conname = 'que_jobs_depends_on_earlier'
+    AND conrelid = 'que_jobs'::regclass
+  ) THEN
+    ALTER TABLE que_jobs
+      ADD CONSTRAINT que_jobs_depends_on_earlier CHECK (job_id > ALL (depends_on));
+  END IF;
+END
+$$;
+
+CREATE INDEX IF NOT EXISTS que_jobs_job_id_idx ON que_jobs (job_id);
+CREATE INDEX IF NOT EXISTS que_jobs_priority_run_at_idx ON que_jobs (priority, run_at, job_id);
+CREATE INDEX IF NOT EXISTS que_jobs_group_id_idx ON que_jobs (group_id) WHERE group_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS que_jobs_debounce_key_idx ON que_jobs (debounce_key, run_at) WHERE debounce_key IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS que_jobs_idempotency_key_idx ON que_jobs (idempotency_key) WHERE idempotency_key IS NOT NULL;
+
+COMMENT ON TABLE que_jobs IS '4';
+
+CREATE TABLE IF NOT EXISTS que_queue_state
+(
+  queue     text        NOT NULL PRIMARY KEY,
+  paused_at timestamptz NOT NULL DEFAULT now()
+);
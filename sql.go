@@ -23,29 +23,189 @@
 
 package que
 
+import "strings"
+
 // Thanks to RhodiumToad in #postgresql for help with the job lock CTE.
+//
+// Jobs are locked in a stable order: by priority (lowest first), then run_at
+// (oldest first), then job_id, which breaks ties between jobs enqueued at the
+// same instant in insertion order. This order matches the primary key of
+// que_jobs. With LockOrderFIFO the priority is left out, and with
+// LockOrderDeadline jobs are first ordered by deadline, see the _fifo and
+// _deadline variants of the statements.
+//
+// The advisory lock key of a job is its job_id, as in Ruby Que, unless the
+// Client has an AdvisoryLockClass. The lock statements take that class as
+// their last parameter and then use (class << 32) | (job_id & 0xFFFFFFFF).
+var (
+	sqlLockJob = lockJobSQL("", "$2", orderPriority)
+
+	// sqlLockJobMatching is sqlLockJob restricted to jobs whose tags contain
+	// all of the key/value pairs in $2.
+	sqlLockJobMatching = lockJobSQL("AND tags @> $2::jsonb", "$3", orderPriority)
+
+	// sqlLockJobExcluding is sqlLockJob skipping the job IDs in $2, which are
+	// the ones already locked by this session. Advisory locks are reentrant,
+	// so without that sqlLockJob would lock the same job again.
+	sqlLockJobExcluding = lockJobSQL("AND job_id <> ALL($2::bigint[])", "$3", orderPriority)
+
+	sqlLockJobFIFO          = lockJobSQL("", "$2", orderFIFO)
+	sqlLockJobMatchingFIFO  = lockJobSQL("AND tags @> $2::jsonb", "$3", orderFIFO)
+	sqlLockJobExcludingFIFO = lockJobSQL("AND job_id <> ALL($2::bigint[])", "$3", orderFIFO)
+
+	sqlLockJobDeadline          = lockJobSQL("", "$2", orderDeadline)
+	sqlLockJobMatchingDeadline  = lockJobSQL("AND tags @> $2::jsonb", "$3", orderDeadline)
+	sqlLockJobExcludingDeadline = lockJobSQL("AND job_id <> ALL($2::bigint[])", "$3", orderDeadline)
+
+	// The _skip_locked variants are used by SkipLockedLocker. They take the
+	// same parameters except for the lock class.
+	sqlLockJobSkipLocked                  = skipLockedJobSQL("", orderPriority)
+	sqlLockJobMatchingSkipLocked          = skipLockedJobSQL("AND tags @> $2::jsonb", orderPriority)
+	sqlLockJobExcludingSkipLocked         = skipLockedJobSQL("AND job_id <> ALL($2::bigint[])", orderPriority)
+	sqlLockJobFIFOSkipLocked              = skipLockedJobSQL("", orderFIFO)
+	sqlLockJobMatchingFIFOSkipLocked      = skipLockedJobSQL("AND tags @> $2::jsonb", orderFIFO)
+	sqlLockJobExcludingFIFOSkipLocked     = skipLockedJobSQL("AND job_id <> ALL($2::bigint[])", orderFIFO)
+	sqlLockJobDeadlineSkipLocked          = skipLockedJobSQL("", orderDeadline)
+	sqlLockJobMatchingDeadlineSkipLocked  = skipLockedJobSQL("AND tags @> $2::jsonb", orderDeadline)
+	sqlLockJobExcludingDeadlineSkipLocked = skipLockedJobSQL("AND job_id <> ALL($2::bigint[])", orderDeadline)
+
+	// sqlLockAnyJob is sqlLockJob for jobs of any queue, taking only the
+	// lock class. Its order is served by que_jobs_priority_run_at_idx rather
+	// than the primary key.
+	sqlLockAnyJob                   = lockAnyJobSQL("$1", orderPriority)
+	sqlLockAnyJobFIFO               = lockAnyJobSQL("$1", orderFIFO)
+	sqlLockAnyJobDeadline           = lockAnyJobSQL("$1", orderDeadline)
+	sqlLockAnyJobSkipLocked         = skipLockedAnyJobSQL(orderPriority)
+	sqlLockAnyJobFIFOSkipLocked     = skipLockedAnyJobSQL(orderFIFO)
+	sqlLockAnyJobDeadlineSkipLocked = skipLockedAnyJobSQL(orderDeadline)
+
+	sqlPeekJob         = peekJobSQL(orderPriority)
+	sqlPeekJobFIFO     = peekJobSQL(orderFIFO)
+	sqlPeekJobDeadline = peekJobSQL(orderDeadline)
+)
+
+// The expressions that jobs are locked in order of, for LockOrderPriority,
+// LockOrderFIFO and LockOrderDeadline. {{t}} stands for the table qualifier,
+// if any. Jobs without a deadline come last; coalesce is used rather than
+// NULLS LAST so that the row comparison in sqlLockJobTemplate works.
 const (
-	sqlLockJob = `
+	orderPriority = "{{t}}priority, {{t}}run_at, {{t}}job_id"
+	orderFIFO     = "{{t}}run_at, {{t}}job_id"
+	orderDeadline = "coalesce({{t}}deadline, 'infinity'::timestamptz), {{t}}priority, {{t}}run_at, {{t}}job_id"
+)
+
+// The conditions of the lock statements on the queue of the candidate jobs:
+// either the queue $1, which is checked for being paused once, or any queue
+// that is not paused.
+const (
+	lockOneQueue   = "queue = $1::text"
+	oneQueuePaused = "AND NOT EXISTS (SELECT 1 FROM que_queue_state WHERE que_queue_state.queue = $1::text)"
+	lockAnyQueue   = "true"
+	anyQueuePaused = "AND NOT EXISTS (SELECT 1 FROM que_queue_state WHERE que_queue_state.queue = j.queue)"
+)
+
+// lockJobSQL returns a statement that locks the first job of queue $1 by
+// order that is ready to run, not expired, not locked yet and whose
+// dependencies have all been deleted, unless the queue is paused. filter is
+// an extra condition on the candidate jobs and class is the parameter holding
+// the advisory lock class.
+func lockJobSQL(filter, class, order string) string {
+	return lockSQL(lockOneQueue, oneQueuePaused, "", filter, class, order)
+}
+
+// lockAnyJobSQL returns a statement like lockJobSQL that locks the first job
+// of any queue that is not paused.
+func lockAnyJobSQL(class, order string) string {
+	return lockSQL(lockAnyQueue, anyQueuePaused, anyQueuePaused, "", class, order)
+}
+
+// lockSQL fills in sqlLockJobTemplate. paused and pausedNext are the pause
+// checks of the first and of the following candidate jobs.
+func lockSQL(queue, paused, pausedNext, filter, class, order string) string {
+	return strings.NewReplacer(
+		"{{queue}}", queue,
+		"{{paused}}", paused,
+		"{{pausedNext}}", pausedNext,
+		"{{filter}}", filter,
+		"{{class}}", class,
+		"{{order}}", strings.Replace(order, "{{t}}", "", -1),
+		"{{jobsOrder}}", strings.Replace(order, "{{t}}", "jobs.", -1),
+	).Replace(sqlLockJobTemplate)
+}
+
+// skipLockedJobSQL returns a statement that selects the same job as
+// lockJobSQL, but locks its row with FOR UPDATE SKIP LOCKED instead of taking
+// an advisory lock, so it must run in a transaction.
+func skipLockedJobSQL(filter, order string) string {
+	return skipLockedSQL(lockOneQueue, oneQueuePaused, filter, order)
+}
+
+// skipLockedAnyJobSQL is skipLockedJobSQL for jobs of any queue.
+func skipLockedAnyJobSQL(order string) string {
+	return skipLockedSQL(lockAnyQueue, anyQueuePaused, "", order)
+}
+
+func skipLockedSQL(queue, paused, filter, order string) string {
+	return nextJobSQL(queue, paused, filter, order) + `FOR UPDATE OF j SKIP LOCKED
+`
+}
+
+// peekJobSQL returns a statement that reads the job lockJobSQL would lock
+// first, without locking it.
+func peekJobSQL(order string) string {
+	return nextJobSQL(lockOneQueue, oneQueuePaused, "", order)
+}
+
+// nextJobSQL returns a statement that selects the first job by order that
+// meets the conditions of sqlLockJobTemplate, without locking it.
+func nextJobSQL(queue, paused, filter, order string) string {
+	return `
+SELECT ` + sqlJobColumns + `
+FROM que_jobs AS j
+WHERE ` + queue + `
+` + filter + `
+AND run_at <= now()
+AND (expires_at IS NULL OR expires_at > now())
+AND (depends_on = '{}' OR NOT EXISTS (SELECT 1 FROM que_jobs AS dep WHERE dep.job_id = ANY(j.depends_on)))
+` + paused + `
+ORDER BY ` + strings.Replace(order, "{{t}}", "", -1) + `
+LIMIT 1
+`
+}
+
+const (
+	// sqlJobColumns are the columns read into a Job by scanJob, in order.
+	sqlJobColumns = "queue, priority, run_at, job_id, job_class, args, error_count, last_error, tags, expires_at, deadline, group_id, completion_webhook"
+
+	sqlLockJobTemplate = `
 WITH RECURSIVE jobs AS (
-  SELECT (j).*, pg_try_advisory_lock((j).job_id) AS locked
+  SELECT (j).*, pg_try_advisory_lock(CASE WHEN {{class}}::integer = 0 THEN (j).job_id ELSE ({{class}}::integer::bigint << 32) | ((j).job_id & 4294967295) END) AS locked
   FROM (
     SELECT j
     FROM que_jobs AS j
-    WHERE queue = $1::text
+    WHERE {{queue}}
+    {{filter}}
     AND run_at <= now()
-    ORDER BY priority, run_at, job_id
+    AND (expires_at IS NULL OR expires_at > now())
+    AND (depends_on = '{}' OR NOT EXISTS (SELECT 1 FROM que_jobs AS dep WHERE dep.job_id = ANY(j.depends_on)))
+    {{paused}}
+    ORDER BY {{order}}
     LIMIT 1
   ) AS t1
   UNION ALL (
-    SELECT (j).*, pg_try_advisory_lock((j).job_id) AS locked
+    SELECT (j).*, pg_try_advisory_lock(CASE WHEN {{class}}::integer = 0 THEN (j).job_id ELSE ({{class}}::integer::bigint << 32) | ((j).job_id & 4294967295) END) AS locked
     FROM (
       SELECT (
         SELECT j
         FROM que_jobs AS j
-        WHERE queue = $1::text
+        WHERE {{queue}}
+        {{filter}}
         AND run_at <= now()
-        AND (priority, run_at, job_id) > (jobs.priority, jobs.run_at, jobs.job_id)
-        ORDER BY priority, run_at, job_id
+        AND (expires_at IS NULL OR expires_at > now())
+        AND (depends_on = '{}' OR NOT EXISTS (SELECT 1 FROM que_jobs AS dep WHERE dep.job_id = ANY(j.depends_on)))
+        {{pausedNext}}
+        AND ({{order}}) > ({{jobsOrder}})
+        ORDER BY {{order}}
         LIMIT 1
       ) AS j
       FROM jobs
@@ -54,14 +214,35 @@ WITH RECURSIVE jobs AS (
     ) AS t1
   )
 )
-SELECT queue, priority, run_at, job_id, job_class, args, error_count
+SELECT ` + sqlJobColumns + `
 FROM jobs
 WHERE locked
 LIMIT 1
+`
+
+	// sqlLockJobByID locks the job with ID $1 whatever its queue and run_at,
+	// unless it is locked already. The LIMIT keeps the subquery from being
+	// flattened, so that only that job's lock is tried.
+	sqlLockJobByID = `
+SELECT ` + sqlJobColumns + `
+FROM (
+  SELECT ` + sqlJobColumns + `
+  FROM que_jobs
+  WHERE job_id = $1::bigint
+  LIMIT 1
+) AS j
+WHERE pg_try_advisory_lock(CASE WHEN $2::integer = 0 THEN job_id ELSE ($2::integer::bigint << 32) | (job_id & 4294967295) END)
+`
+
+	sqlLockJobByIDSkipLocked = `
+SELECT ` + sqlJobColumns + `
+FROM que_jobs
+WHERE job_id = $1::bigint
+FOR UPDATE SKIP LOCKED
 `
 
 	sqlUnlockJob = `
-SELECT pg_advisory_unlock($1)
+SELECT pg_advisory_unlock(CASE WHEN $2::integer = 0 THEN $1::bigint ELSE ($2::integer::bigint << 32) | ($1::bigint & 4294967295) END)
 `
 
 	sqlCheckJob = `
@@ -73,22 +254,120 @@ AND    run_at   = $3::timestamptz
 AND    job_id   = $4::bigint
 `
 
+	// sqlSetError raises the priority by $9 per failure, like
+	// sqlAgePriorities.
 	sqlSetError = `
 UPDATE que_jobs
 SET error_count = $1::integer,
-    run_at      = now() + $2::bigint * '1 second'::interval,
-    last_error  = $3::text
+    run_at      = coalesce($8::timestamptz, now()) + $2::bigint * '1 second'::interval,
+    last_error  = $3::text,
+    locked_by   = NULL,
+    priority    = greatest(priority - $9::smallint, 0::smallint)
 WHERE queue     = $4::text
 AND   priority  = $5::smallint
 AND   run_at    = $6::timestamptz
 AND   job_id    = $7::bigint
+RETURNING run_at, priority
+`
+
+	sqlRescheduleJob = `
+UPDATE que_jobs
+SET run_at    = $1::timestamptz,
+    locked_by = NULL
+WHERE queue    = $2::text
+AND   priority = $3::smallint
+AND   run_at   = $4::timestamptz
+AND   job_id   = $5::bigint
+RETURNING run_at
+`
+
+	sqlUpdateArgs = `
+UPDATE que_jobs
+SET args = $1::json
+WHERE queue    = $2::text
+AND   priority = $3::smallint
+AND   run_at   = $4::timestamptz
+AND   job_id   = $5::bigint
 `
 
-	sqlInsertJob = `
+	sqlSetLockedBy = `
+UPDATE que_jobs
+SET locked_by = $1::text
+WHERE queue    = $2::text
+AND   priority = $3::smallint
+AND   run_at   = $4::timestamptz
+AND   job_id   = $5::bigint
+`
+
+	sqlSetWorkedByLabels = `
+UPDATE que_jobs
+SET worked_by_labels = $1::jsonb
+WHERE queue    = $2::text
+AND   priority = $3::smallint
+AND   run_at   = $4::timestamptz
+AND   job_id   = $5::bigint
+`
+
+	// sqlInsertJobValues inserts nothing if a job with the same
+	// idempotency_key exists, or if a job with the same debounce_key $13 has
+	// not yet reached its run_at. Other unique violations are errors.
+	sqlInsertJobValues = `
 INSERT INTO que_jobs
-(queue, priority, run_at, job_class, args)
-VALUES
-(coalesce($1::text, ''::text), coalesce($2::smallint, 100::smallint), coalesce($3::timestamptz, now()::timestamptz), $4::text, coalesce($5::json, '[]'::json))
+(queue, priority, run_at, job_class, args, tags, depends_on, idempotency_key, expires_at, deadline, group_id, completion_webhook, debounce_key)
+SELECT coalesce($1::text, ''::text), coalesce($2::smallint, 100::smallint), coalesce($3::timestamptz, now()::timestamptz), $4::text, coalesce($5::json, '[]'::json), coalesce($6::jsonb, '{}'::jsonb), coalesce($7::bigint[], '{}'::bigint[]), $8::text, $9::timestamptz, $10::timestamptz, $11::text, $12::text, $13::text
+WHERE $13::text IS NULL
+OR    NOT EXISTS (
+        SELECT 1
+        FROM que_jobs
+        WHERE debounce_key = $13::text
+        AND   run_at > now()
+      )
+ON CONFLICT (idempotency_key) WHERE idempotency_key IS NOT NULL DO NOTHING
+`
+
+	// sqlLockDebounce takes the transaction lock of the debounce key $1,
+	// which serializes the enqueues of a key until they commit, so that each
+	// sees whether the one before inserted a job. It uses the two-key form
+	// of the lock, like sqlLockGroup.
+	sqlLockDebounce = `
+SELECT true
+FROM pg_advisory_xact_lock(hashtext('que_debounce'), hashtext($1::text))
+`
+
+	// sqlAsyncCommit turns off synchronous_commit for the current
+	// transaction, like SET LOCAL.
+	sqlAsyncCommit = `
+SELECT set_config('synchronous_commit', 'off', true)
+`
+
+	sqlInsertJob = sqlInsertJobValues + `RETURNING job_id
+`
+
+	// sqlInsertJobNotify inserts a job like sqlInsertJob and sends a
+	// notification with its queue and job_id on the channel $14, which is
+	// delivered once the transaction commits. The notify CTE calls a volatile
+	// function, so it is not inlined, and joining it makes sure it runs.
+	sqlInsertJobNotify = `
+WITH job AS (` + sqlInsertJobValues + `RETURNING queue, job_id
+), notify AS (
+  SELECT pg_notify($14::text, json_build_object('queue', queue, 'id', job_id)::text)
+  FROM job
+)
+SELECT job.job_id
+FROM job, notify
+`
+
+	// sqlLockGroup takes the transaction lock of the group $2 for the lock
+	// class $1. The two-key form of the lock cannot collide with the job
+	// locks, which use a single bigint key.
+	sqlLockGroup = `
+SELECT pg_advisory_xact_lock($1::integer, hashtext($2::text))
+`
+
+	sqlGroupRemaining = `
+SELECT count(*)
+FROM que_jobs
+WHERE group_id = $1::text
 `
 
 	sqlDeleteJob = `
@@ -97,6 +376,221 @@ WHERE queue    = $1::text
 AND   priority = $2::smallint
 AND   run_at   = $3::timestamptz
 AND   job_id   = $4::bigint
+`
+
+	sqlGetJob = `
+SELECT ` + sqlJobColumns + `, locked_by, depends_on, worked_by_labels,
+       EXISTS (
+         SELECT 1
+         FROM pg_locks
+         WHERE locktype = 'advisory'
+         AND objsubid = 1
+         AND granted
+         AND database = (SELECT oid FROM pg_database WHERE datname = current_database())
+         AND (classid::bigint << 32) | objid::bigint = CASE WHEN $2::integer = 0 THEN job_id ELSE ($2::integer::bigint << 32) | (job_id & 4294967295) END
+       ) AS locked
+FROM que_jobs
+WHERE job_id = $1::bigint
+`
+
+	// sqlJobFilter selects the jobs matching a JobFilter with the queue $1,
+	// or all queues if it is null, the type $2, or all types if it is null,
+	// at least $3 errors, at most $4 errors unless it is null, and only
+	// failing jobs if $5 is true.
+	sqlJobFilter = `
+WHERE ($1::text IS NULL OR queue = $1::text)
+AND   ($2::text IS NULL OR job_class = $2::text)
+AND   error_count >= $3::integer
+AND   ($4::integer IS NULL OR error_count <= $4::integer)
+AND   (NOT $5::boolean OR error_count > 0)
+`
+
+	// sqlListJobs reads the jobs matching sqlJobFilter like sqlGetJob, with
+	// the lock class in $6, the limit in $7, which is unlimited if null, and
+	// the offset in $8.
+	sqlListJobs = `
+SELECT ` + sqlJobColumns + `, locked_by, depends_on, worked_by_labels,
+       EXISTS (
+         SELECT 1
+         FROM pg_locks
+         WHERE locktype = 'advisory'
+         AND objsubid = 1
+         AND granted
+         AND database = (SELECT oid FROM pg_database WHERE datname = current_database())
+         AND (classid::bigint << 32) | objid::bigint = CASE WHEN $6::integer = 0 THEN job_id ELSE ($6::integer::bigint << 32) | (job_id & 4294967295) END
+       ) AS locked
+FROM que_jobs` + sqlJobFilter + `ORDER BY job_id
+LIMIT $7::bigint
+OFFSET $8::bigint
+`
+
+	sqlCountJobs = `
+SELECT count(*)
+FROM que_jobs` + sqlJobFilter
+
+	// sqlQueueStatsSelect counts a job as working if some session holds its
+	// advisory lock for the lock class in $1, like sqlGetJob.
+	sqlQueueStatsSelect = `
+       count(*)                                   AS count,
+       count(*) FILTER (WHERE run_at <= now())    AS count_ready,
+       count(locks.key)                           AS count_working,
+       count(*) FILTER (WHERE error_count > 0)    AS count_errored,
+       min(run_at)                                AS oldest_run_at
+FROM que_jobs
+LEFT JOIN (
+  SELECT (classid::bigint << 32) | objid::bigint AS key
+  FROM pg_locks
+  WHERE locktype = 'advisory'
+  AND objsubid = 1
+  AND granted
+  AND database = (SELECT oid FROM pg_database WHERE datname = current_database())
+) locks ON locks.key = CASE WHEN $1::integer = 0 THEN job_id ELSE ($1::integer::bigint << 32) | (job_id & 4294967295) END`
+
+	sqlQueueStats = `
+SELECT queue,` + sqlQueueStatsSelect + `
+GROUP BY queue
+`
+
+	sqlOneQueueStats = `
+SELECT` + sqlQueueStatsSelect + `
+WHERE queue = $2::text
+`
+
+	sqlJobExists = `
+SELECT EXISTS (SELECT 1 FROM que_jobs WHERE job_id = $1::bigint)
+`
+
+	sqlLockHolder = `
+SELECT pid
+FROM pg_locks
+WHERE locktype = 'advisory'
+AND objsubid = 1
+AND granted
+AND database = (SELECT oid FROM pg_database WHERE datname = current_database())
+AND (classid::bigint << 32) | objid::bigint = CASE WHEN $2::integer = 0 THEN $1::bigint ELSE ($2::integer::bigint << 32) | ($1::bigint & 4294967295) END
+LIMIT 1
+`
+
+	// sqlAdvisoryLockCount counts the single-key advisory locks whose upper
+	// 32 bits are the lock class $1, held by any session of the current
+	// database. pg_locks covers the whole cluster, so like the other
+	// statements reading it, it filters by database.
+	sqlAdvisoryLockCount = `
+SELECT count(*)
+FROM pg_locks
+WHERE locktype = 'advisory'
+AND objsubid = 1
+AND granted
+AND database = (SELECT oid FROM pg_database WHERE datname = current_database())
+AND classid::bigint = ($1::integer::bigint & 4294967295)
+`
+
+	sqlCountByType = `
+SELECT count(*)
+FROM que_jobs
+WHERE queue     = $1::text
+AND   job_class = $2::text
+`
+
+	sqlScheduledWithin = `
+SELECT count(*)
+FROM que_jobs
+WHERE queue  = $1::text
+AND   run_at > now()
+AND   run_at <= now() + $2::float8 * interval '1 second'
+`
+
+	// Maintenance statements skip jobs that are being worked by trying to take
+	// the job's advisory lock for the duration of the statement. The lock key
+	// is derived from the lock class in the last parameter like in sqlLockJob.
+	sqlPurgeByType = `
+DELETE FROM que_jobs
+WHERE queue     = $1::text
+AND   job_class = $2::text
+AND   pg_try_advisory_xact_lock(CASE WHEN $3::integer = 0 THEN job_id ELSE ($3::integer::bigint << 32) | (job_id & 4294967295) END)
+`
+
+	sqlDeleteOlderThan = `
+DELETE FROM que_jobs
+WHERE queue     = $1::text
+AND   run_at    < now() - $2::float8 * interval '1 second'
+AND   pg_try_advisory_xact_lock(CASE WHEN $3::integer = 0 THEN job_id ELSE ($3::integer::bigint << 32) | (job_id & 4294967295) END)
+`
+
+	// sqlMoveQueue selects the jobs to move in lock order, so the ones that
+	// would be worked first are moved first, skipping the ones whose advisory
+	// lock is held. As in sqlLockJobByID, the LIMIT keeps the inner subquery
+	// from being flattened, so that only the locks of those jobs are tried.
+	sqlMoveQueue = `
+UPDATE que_jobs
+SET queue = $2::text
+WHERE queue = $1::text
+AND   job_id IN (
+  SELECT job_id
+  FROM (
+    SELECT job_id
+    FROM que_jobs
+    WHERE queue = $1::text
+    AND NOT EXISTS (
+      SELECT 1
+      FROM pg_locks
+      WHERE locktype = 'advisory'
+      AND objsubid = 1
+      AND granted
+      AND database = (SELECT oid FROM pg_database WHERE datname = current_database())
+      AND (classid::bigint << 32) | objid::bigint = CASE WHEN $4::integer = 0 THEN job_id ELSE ($4::integer::bigint << 32) | (job_id & 4294967295) END
+    )
+    ORDER BY priority, run_at, job_id
+    LIMIT $3::integer
+  ) AS candidates
+  WHERE pg_try_advisory_xact_lock(CASE WHEN $4::integer = 0 THEN job_id ELSE ($4::integer::bigint << 32) | (job_id & 4294967295) END)
+)
+`
+
+	sqlAgePriorities = `
+UPDATE que_jobs
+SET priority = greatest(priority - $1::smallint, 0::smallint)
+WHERE priority > 0
+AND   run_at   < now() - $2::float8 * interval '1 second'
+AND   pg_try_advisory_xact_lock(CASE WHEN $3::integer = 0 THEN job_id ELSE ($3::integer::bigint << 32) | (job_id & 4294967295) END)
+`
+
+	sqlSetPriority = `
+UPDATE que_jobs
+SET priority = $2::smallint
+WHERE job_id = $1::bigint
+AND   pg_try_advisory_xact_lock(CASE WHEN $3::integer = 0 THEN job_id ELSE ($3::integer::bigint << 32) | (job_id & 4294967295) END)
+`
+
+	sqlDeleteExpired = `
+DELETE FROM que_jobs
+WHERE expires_at <= now()
+AND   pg_try_advisory_xact_lock(CASE WHEN $1::integer = 0 THEN job_id ELSE ($1::integer::bigint << 32) | (job_id & 4294967295) END)
+RETURNING ` + sqlJobColumns + `
+`
+
+	sqlPauseQueue = `
+INSERT INTO que_queue_state (queue)
+VALUES ($1::text)
+ON CONFLICT (queue) DO NOTHING
+`
+
+	sqlResumeQueue = `
+DELETE FROM que_queue_state
+WHERE queue = $1::text
+`
+
+	sqlJobsTableExists = `
+SELECT to_regclass('que_jobs') IS NOT NULL
+`
+
+	// sqlTableColumns returns no rows if the table $1 does not exist.
+	sqlTableColumns = `
+SELECT attname
+FROM pg_attribute
+WHERE attrelid = to_regclass($1::text)
+AND attnum > 0
+AND NOT attisdropped
 `
 
 	sqlJobStats = `
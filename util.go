@@ -1,5 +1,7 @@
 package que
 
+import "math/rand"
+
 // intPow returns x**y, the base-x exponential of y.
 func intPow(x, y int) (r int) {
 	if x == r || y < r {
@@ -24,3 +26,15 @@ func intPow(x, y int) (r int) {
 	}
 	return
 }
+
+// jitter randomizes delay by up to fraction of its value in either direction.
+func jitter(delay int, fraction float64) int {
+	if fraction <= 0 || delay <= 0 {
+		return delay
+	}
+	d := int(float64(delay) * (1 + fraction*(2*rand.Float64()-1)))
+	if d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,94 @@
+package que
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// CompletionWebhookType is the Type of the jobs that deliver the
+// notifications of jobs with a CompletionWebhook.
+const CompletionWebhookType = "que.CompletionWebhook"
+
+// The statuses of a CompletionNotice.
+const (
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// CompletionNotice is the JSON body POSTed to the CompletionWebhook of a job
+// once it is done. Status is JobSucceeded, or JobFailed if the job was
+// deleted without succeeding, e.g. because it ran out of retries or was
+// discarded.
+type CompletionNotice struct {
+	JobID   int64  `json:"job_id"`
+	JobType string `json:"job_type"`
+	Queue   string `json:"queue"`
+	Status  string `json:"status"`
+}
+
+// webhookArgs are the Args of a CompletionWebhookType job.
+type webhookArgs struct {
+	URL    string           `json:"url"`
+	Notice CompletionNotice `json:"notice"`
+}
+
+// CompletionWebhookWorkFunc returns the WorkFunc of CompletionWebhookType
+// jobs, which POSTs the notice with hc, or http.DefaultClient if hc is nil.
+// A response that is not a 2xx fails the job, so it is retried.
+func CompletionWebhookWorkFunc(hc *http.Client) WorkFunc {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return func(j *Job) error {
+		var args webhookArgs
+		if err := json.Unmarshal(j.Args, &args); err != nil {
+			return err
+		}
+		body, err := json.Marshal(args.Notice)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodPost, args.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := hc.Do(req.WithContext(j.Context()))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("completion webhook returned %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+// notifyCompletion enqueues the notification of j if it has a
+// CompletionWebhook and was deleted while being worked, with err the result
+// of working it. The notification is enqueued after the job was deleted, so
+// it is lost if enqueueing fails.
+func (w *Worker) notifyCompletion(j *Job, err *error) {
+	if j.CompletionWebhook == "" || !j.IsDeleted() {
+		return
+	}
+	status := JobSucceeded
+	if *err != nil {
+		status = JobFailed
+	}
+	args, merr := json.Marshal(webhookArgs{
+		URL:    j.CompletionWebhook,
+		Notice: CompletionNotice{JobID: j.ID, JobType: j.Type, Queue: j.Queue, Status: status},
+	})
+	if merr != nil {
+		log.Printf("attempting to encode completion notice of job %d: %v", j.ID, merr)
+		return
+	}
+	if eerr := w.c.Enqueue(&Job{Type: CompletionWebhookType, Queue: j.Queue, Args: args}); eerr != nil {
+		log.Printf("attempting to enqueue completion notice of job %d: %v", j.ID, eerr)
+	}
+}
@@ -1,7 +1,12 @@
 package que
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -79,6 +84,67 @@ func TestLockJob(t *testing.T) {
 	}
 }
 
+func TestLockJobAdvisoryLockClass(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+	c.AdvisoryLockClass = 42
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+
+	var count int64
+	query := "SELECT count(*) FROM pg_locks WHERE locktype=$1 AND classid=$2::bigint AND objid=$3::bigint"
+	if err = c.pool.QueryRow(query, "advisory", 42, j.ID).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("want 1 advisory lock in class 42, got %d", count)
+	}
+
+	j.Done()
+	if err = c.pool.QueryRow(query, "advisory", 42, j.ID).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("advisory lock was not released")
+	}
+}
+
+func TestLockJobDisableRaceCheck(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+	c.DisableRaceCheck = true
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	if err = j.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	j.Done()
+
+	if j, err = c.LockJob(""); err != nil || j != nil {
+		t.Errorf("want no job after the only one was deleted, got %+v (err %v)", j, err)
+	}
+}
+
 func TestLockJobAlreadyLocked(t *testing.T) {
 	c := openTestClient(t)
 	defer truncateAndClose(c.pool)
@@ -106,82 +172,160 @@ func TestLockJobAlreadyLocked(t *testing.T) {
 	}
 }
 
-func TestLockJobNoJob(t *testing.T) {
+func TestLockJobSkipLockedLocker(t *testing.T) {
 	c := openTestClient(t)
 	defer truncateAndClose(c.pool)
+	c.Locker = &SkipLockedLocker{}
+
+	for i := 0; i < 2; i++ {
+		if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	jobs, err := c.LockJobs(context.Background(), "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		DoneAll(jobs)
+		t.Fatalf("want 2 jobs, got %d", len(jobs))
+	}
 
 	j, err := c.LockJob("")
 	if err != nil {
 		t.Fatal(err)
 	}
 	if j != nil {
-		t.Errorf("want no job, got %v", j)
+		j.Done()
+		t.Fatalf("wanted no job, got %+v", j)
 	}
-}
-
-func TestLockJobCustomQueue(t *testing.T) {
-	c := openTestClient(t)
-	defer truncateAndClose(c.pool)
 
-	if err := c.Enqueue(&Job{Type: "MyJob", Queue: "extra_priority"}); err != nil {
+	// The delete is committed once the last job of the batch is done.
+	if err := jobs[0].Delete(); err != nil {
 		t.Fatal(err)
 	}
 
-	j, err := c.LockJob("")
+	jobs[0].Done()
+	j, err = c.LockJob("")
 	if err != nil {
 		t.Fatal(err)
 	}
 	if j != nil {
 		j.Done()
-		t.Errorf("expected no job to be found with empty queue name, got %+v", j)
+		t.Fatalf("wanted no job while the batch is locked, got %+v", j)
 	}
 
-	j, err = c.LockJob("extra_priority")
+	jobs[1].Done()
+	j, err = c.LockJob("")
 	if err != nil {
 		t.Fatal(err)
 	}
+	if j == nil {
+		t.Fatal("wanted the job that was not deleted")
+	}
 	defer j.Done()
+	if j.ID != jobs[1].ID {
+		t.Errorf("want job %d, got %d", jobs[1].ID, j.ID)
+	}
+}
 
-	if j == nil {
-		t.Fatal("wanted job, got none")
+func TestSkipLockedLockerRolledBack(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	for i := 0; i < 2; i++ {
+		if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+			t.Fatal(err)
+		}
 	}
 
-	if err = j.Delete(); err != nil {
+	conn, err := c.pool.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.pool.Release(conn)
+
+	l := &SkipLockedLocker{}
+	var j1, j2 Job
+	for _, j := range []*Job{&j1, &j2} {
+		if err := l.Lock(context.Background(), conn, "que_lock_job", j, "", c.AdvisoryLockClass); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := conn.Exec("SELECT 1/0"); err == nil {
+		t.Fatal("want the statement to fail")
+	}
+	if err := l.Unlock(conn, &j1); err != nil {
 		t.Fatal(err)
 	}
+	if err := l.Unlock(conn, &j2); err != ErrTxRolledBack {
+		t.Errorf("want ErrTxRolledBack, got %v", err)
+	}
 }
 
-func TestJobConn(t *testing.T) {
+func TestLockJobSkipLockedLockerGroup(t *testing.T) {
 	c := openTestClient(t)
 	defer truncateAndClose(c.pool)
+	c.Locker = &SkipLockedLocker{}
+	var done []string
+	c.OnGroupDone = func(groupID string) {
+		done = append(done, groupID)
+	}
 
-	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+	for i := 0; i < 2; i++ {
+		if err := c.Enqueue(&Job{Type: "Part", GroupID: "import-1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jobs, err := c.LockJobs(context.Background(), "", 2)
+	if err != nil {
 		t.Fatal(err)
 	}
+	defer DoneAll(jobs)
+	if len(jobs) != 2 {
+		t.Fatalf("want 2 jobs, got %d", len(jobs))
+	}
 
+	// Deleting a grouped job must not end the transaction holding the
+	// other job of the batch.
+	if err := jobs[0].Delete(); err != nil {
+		t.Fatal(err)
+	}
 	j, err := c.LockJob("")
 	if err != nil {
 		t.Fatal(err)
 	}
-	if j == nil {
-		t.Fatal("wanted job, got none")
+	if j != nil {
+		j.Done()
+		t.Fatalf("wanted no job while the batch is locked, got %+v", j)
+	}
+	if len(done) != 0 {
+		t.Errorf("want no group done while a job is left, got %v", done)
 	}
-	defer j.Done()
 
-	if conn := j.Conn(); conn != j.conn {
-		t.Errorf("want %+v, got %+v", j.conn, conn)
+	if err := jobs[1].Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(done, []string{"import-1"}) {
+		t.Errorf("want the group done once, got %v", done)
 	}
 }
 
-func TestJobConnRace(t *testing.T) {
+func TestLockJobByID(t *testing.T) {
 	c := openTestClient(t)
 	defer truncateAndClose(c.pool)
 
-	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+	first := &Job{Type: "MyJob"}
+	if err := c.Enqueue(first); err != nil {
+		t.Fatal(err)
+	}
+	target := &Job{Type: "MyJob", Queue: "other", RunAt: time.Now().Add(time.Hour)}
+	if err := c.Enqueue(target); err != nil {
 		t.Fatal(err)
 	}
 
-	j, err := c.LockJob("")
+	j, err := c.LockJobByID(context.Background(), target.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -189,199 +333,449 @@ func TestJobConnRace(t *testing.T) {
 		t.Fatal("wanted job, got none")
 	}
 	defer j.Done()
+	if j.ID != target.ID || j.Queue != "other" {
+		t.Errorf("want job %d of queue other, got job %d of queue %q", target.ID, j.ID, j.Queue)
+	}
 
-	var wg sync.WaitGroup
-	wg.Add(2)
+	j2, err := c.LockJobByID(context.Background(), target.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j2 != nil {
+		j2.Done()
+		t.Errorf("wanted no job while it is locked, got %+v", j2)
+	}
 
-	// call Conn and Done in different goroutines to make sure they are safe from
-	// races.
-	go func() {
-		_ = j.Conn()
-		wg.Done()
-	}()
-	go func() {
-		j.Done()
-		wg.Done()
-	}()
-	wg.Wait()
+	if j3, err := c.LockJobByID(context.Background(), target.ID+100); err != nil || j3 != nil {
+		t.Errorf("want no job and no error for a missing ID, got %+v, %v", j3, err)
+	}
 }
 
-// Test the race condition in LockJob
-func TestLockJobAdvisoryRace(t *testing.T) {
-	c := openTestClientMaxConns(t, 2)
-	defer truncateAndClose(c.pool)
+func TestLockError(t *testing.T) {
+	c := openTestClient(t)
+	c.pool.Close()
 
-	// *pgx.ConnPool doesn't support pools of only one connection.  Make sure
-	// the other one is busy so we know which backend will be used by LockJob
-	// below.
-	unusedConn, err := c.pool.Acquire()
-	if err != nil {
-		t.Fatal(err)
+	_, err := c.LockJob("mail")
+	var lockErr *LockError
+	if !errors.As(err, &lockErr) {
+		t.Fatalf("want a LockError, got %v", err)
+	}
+	if lockErr.Queue != "mail" || lockErr.ID != 0 {
+		t.Errorf("want queue mail, got %+v", lockErr)
+	}
+	if !errors.Is(err, pgx.ErrClosedPool) {
+		t.Errorf("want the error to wrap ErrClosedPool, got %v", err)
 	}
-	defer c.pool.Release(unusedConn)
 
-	// We use two jobs: the first one is concurrently deleted, and the second
-	// one is returned by LockJob after recovering from the race condition.
-	for i := 0; i < 2; i++ {
-		if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+	_, err = c.LockJobByID(context.Background(), 42)
+	if !errors.As(err, &lockErr) || lockErr.ID != 42 {
+		t.Errorf("want a LockError for job 42, got %v", err)
+	}
+}
+
+func TestLockAnyJob(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	for _, j := range []*Job{
+		{Type: "MyJob", Queue: "a", Priority: 50},
+		{Type: "MyJob", Queue: "b", Priority: 10},
+		{Type: "MyJob", Queue: "paused", Priority: 1},
+	} {
+		if err := c.Enqueue(j); err != nil {
 			t.Fatal(err)
 		}
 	}
+	if err := c.PauseQueue(context.Background(), "paused"); err != nil {
+		t.Fatal(err)
+	}
 
-	// helper functions
-	newConn := func() *pgx.Conn {
-		conn, err := pgx.Connect(testConnConfig)
+	var queues []string
+	for {
+		j, err := c.LockAnyJob(context.Background())
 		if err != nil {
-			panic(err)
+			t.Fatal(err)
 		}
-		return conn
-	}
-	getBackendPID := func(conn *pgx.Conn) int32 {
-		var backendPID int32
-		err := conn.QueryRow(`
-			SELECT pg_backend_pid()
-		`).Scan(&backendPID)
-		if err != nil {
-			panic(err)
+		if j == nil {
+			break
 		}
-		return backendPID
+		defer j.Done()
+		queues = append(queues, j.Queue)
 	}
-	waitUntilBackendIsWaiting := func(backendPID int32, name string) {
-		conn := newConn()
-		i := 0
-		for {
-			var waiting bool
-			err := conn.QueryRow(`SELECT wait_event is not null from pg_stat_activity where pid=$1`, backendPID).Scan(&waiting)
+	if want := []string{"b", "a"}; !reflect.DeepEqual(queues, want) {
+		t.Errorf("want jobs of queues %v, got %v", want, queues)
+	}
+}
 
-			// This is a fallback. In PostgreSQL version 9.6 'waiting' was replaced with 'wait_event'.
-			if err != nil {
-				err := conn.QueryRow(`SELECT waiting from pg_stat_activity where pid=$1`, backendPID).Scan(&waiting)
-				if err != nil {
-					panic(err)
-				}
-			}
+func TestLockJobNoJob(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
 
-			if waiting {
-				break
-			} else {
-				i++
-				if i >= 10000/50 {
-					panic(fmt.Sprintf("timed out while waiting for %s", name))
-				}
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j != nil {
+		t.Errorf("want no job, got %v", j)
+	}
+}
 
-				time.Sleep(50 * time.Millisecond)
-			}
-		}
+func TestLockJobContextCancelled(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
 
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
 	}
 
-	// Reproducing the race condition is a bit tricky.  The idea is to form a
-	// lock queue on the relation that looks like this:
-	//
-	//   AccessExclusive <- AccessShare  <- AccessExclusive ( <- AccessShare )
-	//
-	// where the leftmost AccessShare lock is the one implicitly taken by the
-	// sqlLockJob query.  Once we release the leftmost AccessExclusive lock
-	// without releasing the rightmost one, the session holding the rightmost
-	// AccessExclusiveLock can run the necessary DELETE before the sqlCheckJob
-	// query runs (since it'll be blocked behind the rightmost AccessExclusive
-	// Lock).
-	//
-	deletedJobIDChan := make(chan int64, 1)
-	lockJobBackendIDChan := make(chan int32)
-	secondAccessExclusiveBackendIDChan := make(chan int32)
-
-	go func() {
-		conn := newConn()
-		defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
-		tx, err := conn.Begin()
-		if err != nil {
-			panic(err)
-		}
-		_, err = tx.Exec(`LOCK TABLE que_jobs IN ACCESS EXCLUSIVE MODE`)
-		if err != nil {
-			panic(err)
-		}
+	j, err := c.LockJobContext(ctx, "")
+	if j != nil {
+		j.Done()
+		t.Fatalf("wanted no job, got %+v", j)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
 
-		// first wait for LockJob to appear behind us
-		backendID := <-lockJobBackendIDChan
-		waitUntilBackendIsWaiting(backendID, "LockJob")
+	// make sure no conn was leaked
+	stat := c.pool.Stat()
+	total, available := stat.CurrentConnections, stat.AvailableConnections
+	if total != available {
+		t.Errorf("want available=total, got available=%d total=%d", available, total)
+	}
+}
 
-		// then for the AccessExclusive lock to appear behind that one
-		backendID = <-secondAccessExclusiveBackendIDChan
-		waitUntilBackendIsWaiting(backendID, "second access exclusive lock")
+func TestLockJobContextCancelledAcquiring(t *testing.T) {
+	c := openTestClientMaxConns(t, 2)
+	defer truncateAndClose(c.pool)
 
-		err = tx.Rollback()
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// exhaust the pool
+	var conns []*pgx.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := c.pool.Acquire()
 		if err != nil {
-			panic(err)
+			t.Fatal(err)
 		}
-	}()
+		conns = append(conns, conn)
+	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	done := make(chan error, 1)
 	go func() {
-		conn := newConn()
-		defer conn.Close()
+		j, err := c.LockJobContext(ctx, "")
+		if j != nil {
+			j.Done()
+		}
+		done <- err
+	}()
 
-		// synchronization point
-		secondAccessExclusiveBackendIDChan <- getBackendPID(conn)
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("want context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("LockJobContext still waiting for a connection after ctx was canceled")
+	}
 
-		tx, err := conn.Begin()
-		if err != nil {
-			panic(err)
+	// the connection acquired after giving up goes back to the pool
+	for _, conn := range conns {
+		c.pool.Release(conn)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stat := c.pool.Stat()
+		if stat.CurrentConnections == stat.AvailableConnections {
+			break
 		}
-		_, err = tx.Exec(`LOCK TABLE que_jobs IN ACCESS EXCLUSIVE MODE`)
-		if err != nil {
-			panic(err)
+		if time.Now().After(deadline) {
+			t.Fatalf("want available=total, got available=%d total=%d", stat.AvailableConnections, stat.CurrentConnections)
 		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
 
-		// Fake a concurrent transaction grabbing the job
-		var jid int64
-		err = tx.QueryRow(`
-			DELETE FROM que_jobs
-			WHERE job_id =
-				(SELECT min(job_id)
-				 FROM que_jobs)
-			RETURNING job_id
-		`).Scan(&jid)
+func TestLockJobCustomQueue(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob", Queue: "extra_priority"}); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j != nil {
+		j.Done()
+		t.Errorf("expected no job to be found with empty queue name, got %+v", j)
+	}
+
+	j, err = c.LockJob("extra_priority")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Done()
+
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+
+	if err = j.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockJobAcquireTimeout(t *testing.T) {
+	c := openTestClientMaxConns(t, 2)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// exhaust the pool
+	for i := 0; i < 2; i++ {
+		conn, err := c.pool.Acquire()
 		if err != nil {
-			panic(err)
+			t.Fatal(err)
 		}
+		defer c.pool.Release(conn)
+	}
 
-		deletedJobIDChan <- jid
+	c.AcquireTimeout = 100 * time.Millisecond
+	j, err := c.LockJob("")
+	if j != nil {
+		j.Done()
+		t.Fatalf("wanted no job, got %+v", j)
+	}
+	if err != ErrPoolBusy {
+		t.Fatalf("want ErrPoolBusy, got %v", err)
+	}
+}
 
-		err = tx.Commit()
-		if err != nil {
-			panic(err)
+func TestLockJobStatementTimeout(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+	c.StatementTimeout = 100 * time.Millisecond
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// block the lock query behind an exclusive lock on the table
+	tx, err := c.pool.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec("LOCK TABLE que_jobs IN ACCESS EXCLUSIVE MODE"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.LockJob("")
+	if !errors.Is(err, ErrStatementTimeout) {
+		t.Errorf("want ErrStatementTimeout, got %v", err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	defer j.Done()
+
+	// the WorkFunc must not run with the timeout
+	var timeout string
+	if err = j.Conn().QueryRow("SHOW statement_timeout").Scan(&timeout); err != nil {
+		t.Fatal(err)
+	}
+	if timeout == "100ms" {
+		t.Errorf("want statement_timeout reset after locking, got %s", timeout)
+	}
+}
+
+func TestLockJobMatching(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	tags := map[string]string{"tenant_id": "42", "source": "api"}
+	if err := c.Enqueue(&Job{Type: "MyJob", Tags: tags}); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := c.LockJobMatching(context.Background(), "", map[string]string{"tenant_id": "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j != nil {
+		j.Done()
+		t.Fatalf("wanted no job for a different tenant, got %+v", j)
+	}
+
+	j, err = c.LockJobMatching(context.Background(), "", map[string]string{"tenant_id": "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	defer j.Done()
+
+	if !reflect.DeepEqual(j.Tags, tags) {
+		t.Errorf("want Tags=%v, got %v", tags, j.Tags)
+	}
+}
+
+func TestLockJobs(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	for i := 0; i < 3; i++ {
+		if err := c.Enqueue(&Job{Type: "MyJob", Priority: int16(10 + i)}); err != nil {
+			t.Fatal(err)
 		}
-	}()
+	}
 
-	conn, err := c.pool.Acquire()
+	jobs, err := c.LockJobs(context.Background(), "", 2)
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("want 2 jobs, got %d", len(jobs))
+	}
+	if jobs[0].Priority != 10 || jobs[1].Priority != 11 {
+		t.Errorf("want jobs locked in priority order, got %d and %d", jobs[0].Priority, jobs[1].Priority)
+	}
+	if jobs[0].Conn() != jobs[1].Conn() {
+		t.Error("want jobs to share a connection")
 	}
-	ourBackendID := getBackendPID(conn)
-	c.pool.Release(conn)
 
-	// synchronization point
-	lockJobBackendIDChan <- ourBackendID
+	// the remaining job can still be locked, but not the batch's
+	rest, err := c.LockJobs(context.Background(), "", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 1 || rest[0].Priority != 12 {
+		t.Fatalf("want only the unlocked job, got %+v", rest)
+	}
+	DoneAll(rest)
 
-	job, err := c.LockJob("")
+	if err = jobs[0].Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if err = jobs[1].Error("retry"); err != nil {
+		t.Fatal(err)
+	}
+	jobs[0].Done()
+	if stat := c.pool.Stat(); stat.AvailableConnections != stat.CurrentConnections-1 {
+		t.Errorf("want the shared connection kept until all jobs are done, got %+v", stat)
+	}
+	DoneAll(jobs)
+	if stat := c.pool.Stat(); stat.AvailableConnections != stat.CurrentConnections {
+		t.Errorf("want all connections back in the pool, got %+v", stat)
+	}
+
+	var locks int
+	if err = c.pool.QueryRow("SELECT count(*) FROM pg_locks WHERE locktype = 'advisory'").Scan(&locks); err != nil {
+		t.Fatal(err)
+	}
+	if locks != 0 {
+		t.Errorf("want no advisory locks left, got %d", locks)
+	}
+}
+
+func TestLockJobDependsOn(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	tx, err := c.pool.Begin()
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	first := &Job{Type: "First", Priority: 200}
+	if err = c.EnqueueInTx(first, tx); err != nil {
+		t.Fatal(err)
+	}
+	// a higher priority must not let the dependent job run first
+	second := &Job{Type: "Second", Priority: 1, DependsOn: []int64{first.ID}}
+	if err = c.EnqueueInTx(second, tx); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
 	}
-	defer job.Done()
 
-	deletedJobID := <-deletedJobIDChan
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil || j.ID != first.ID {
+		t.Fatalf("want the job without dependencies, got %+v", j)
+	}
 
-	t.Logf("Got id %d", job.ID)
-	t.Logf("Concurrently deleted id %d", deletedJobID)
+	// while the first job is being worked, the second one must wait
+	j2, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j2 != nil {
+		j2.Done()
+		t.Fatalf("want no job while the dependency exists, got %+v", j2)
+	}
 
-	if deletedJobID >= job.ID {
-		t.Fatalf("deleted job id %d must be smaller than job.ID %d", deletedJobID, job.ID)
+	if err = j.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	j.Done()
+
+	j2, err = c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
 	}
+	if j2 == nil || j2.ID != second.ID {
+		t.Fatalf("want the dependent job once its dependency is done, got %+v", j2)
+	}
+	j2.Done()
 }
 
-func TestJobDelete(t *testing.T) {
+func TestJobConn(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	defer j.Done()
+
+	if conn := j.Conn(); conn != j.conn {
+		t.Errorf("want %+v, got %+v", j.conn, conn)
+	}
+}
+
+func TestJobConnRace(t *testing.T) {
 	c := openTestClient(t)
 	defer truncateAndClose(c.pool)
 
@@ -389,30 +783,631 @@ func TestJobDelete(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	j, err := c.LockJob("")
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	defer j.Done()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// call Conn and Done in different goroutines to make sure they are safe from
+	// races.
+	go func() {
+		_ = j.Conn()
+		wg.Done()
+	}()
+	go func() {
+		j.Done()
+		wg.Done()
+	}()
+	wg.Wait()
+}
+
+// Test the race condition in LockJob
+func TestLockJobAdvisoryRace(t *testing.T) {
+	c := openTestClientMaxConns(t, 2)
+	defer truncateAndClose(c.pool)
+
+	// *pgx.ConnPool doesn't support pools of only one connection.  Make sure
+	// the other one is busy so we know which backend will be used by LockJob
+	// below.
+	unusedConn, err := c.pool.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.pool.Release(unusedConn)
+
+	// We use two jobs: the first one is concurrently deleted, and the second
+	// one is returned by LockJob after recovering from the race condition.
+	for i := 0; i < 2; i++ {
+		if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// helper functions
+	newConn := func() *pgx.Conn {
+		conn, err := pgx.Connect(testConnConfig)
+		if err != nil {
+			panic(err)
+		}
+		return conn
+	}
+	getBackendPID := func(conn *pgx.Conn) int32 {
+		var backendPID int32
+		err := conn.QueryRow(`
+			SELECT pg_backend_pid()
+		`).Scan(&backendPID)
+		if err != nil {
+			panic(err)
+		}
+		return backendPID
+	}
+	waitUntilBackendIsWaiting := func(backendPID int32, name string) {
+		conn := newConn()
+		i := 0
+		for {
+			var waiting bool
+			err := conn.QueryRow(`SELECT wait_event is not null from pg_stat_activity where pid=$1`, backendPID).Scan(&waiting)
+
+			// This is a fallback. In PostgreSQL version 9.6 'waiting' was replaced with 'wait_event'.
+			if err != nil {
+				err := conn.QueryRow(`SELECT waiting from pg_stat_activity where pid=$1`, backendPID).Scan(&waiting)
+				if err != nil {
+					panic(err)
+				}
+			}
+
+			if waiting {
+				break
+			} else {
+				i++
+				if i >= 10000/50 {
+					panic(fmt.Sprintf("timed out while waiting for %s", name))
+				}
+
+				time.Sleep(50 * time.Millisecond)
+			}
+		}
+
+	}
+
+	// Reproducing the race condition is a bit tricky.  The idea is to form a
+	// lock queue on the relation that looks like this:
+	//
+	//   AccessExclusive <- AccessShare  <- AccessExclusive ( <- AccessShare )
+	//
+	// where the leftmost AccessShare lock is the one implicitly taken by the
+	// sqlLockJob query.  Once we release the leftmost AccessExclusive lock
+	// without releasing the rightmost one, the session holding the rightmost
+	// AccessExclusiveLock can run the necessary DELETE before the sqlCheckJob
+	// query runs (since it'll be blocked behind the rightmost AccessExclusive
+	// Lock).
+	//
+	deletedJobIDChan := make(chan int64, 1)
+	lockJobBackendIDChan := make(chan int32)
+	secondAccessExclusiveBackendIDChan := make(chan int32)
+
+	go func() {
+		conn := newConn()
+		defer conn.Close()
+
+		tx, err := conn.Begin()
+		if err != nil {
+			panic(err)
+		}
+		_, err = tx.Exec(`LOCK TABLE que_jobs IN ACCESS EXCLUSIVE MODE`)
+		if err != nil {
+			panic(err)
+		}
+
+		// first wait for LockJob to appear behind us
+		backendID := <-lockJobBackendIDChan
+		waitUntilBackendIsWaiting(backendID, "LockJob")
+
+		// then for the AccessExclusive lock to appear behind that one
+		backendID = <-secondAccessExclusiveBackendIDChan
+		waitUntilBackendIsWaiting(backendID, "second access exclusive lock")
+
+		err = tx.Rollback()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	go func() {
+		conn := newConn()
+		defer conn.Close()
+
+		// synchronization point
+		secondAccessExclusiveBackendIDChan <- getBackendPID(conn)
+
+		tx, err := conn.Begin()
+		if err != nil {
+			panic(err)
+		}
+		_, err = tx.Exec(`LOCK TABLE que_jobs IN ACCESS EXCLUSIVE MODE`)
+		if err != nil {
+			panic(err)
+		}
+
+		// Fake a concurrent transaction grabbing the job
+		var jid int64
+		err = tx.QueryRow(`
+			DELETE FROM que_jobs
+			WHERE job_id =
+				(SELECT min(job_id)
+				 FROM que_jobs)
+			RETURNING job_id
+		`).Scan(&jid)
+		if err != nil {
+			panic(err)
+		}
+
+		deletedJobIDChan <- jid
+
+		err = tx.Commit()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	conn, err := c.pool.Acquire()
+	if err != nil {
+		panic(err)
+	}
+	ourBackendID := getBackendPID(conn)
+	c.pool.Release(conn)
+
+	// synchronization point
+	lockJobBackendIDChan <- ourBackendID
+
+	job, err := c.LockJob("")
+	if err != nil {
+		panic(err)
+	}
+	defer job.Done()
+
+	deletedJobID := <-deletedJobIDChan
+
+	t.Logf("Got id %d", job.ID)
+	t.Logf("Concurrently deleted id %d", deletedJobID)
+
+	if deletedJobID >= job.ID {
+		t.Fatalf("deleted job id %d must be smaller than job.ID %d", deletedJobID, job.ID)
+	}
+}
+
+// racingLocker is an AdvisoryLocker whose first races Checks report the job
+// as worked by another session, as in TestLockJobAdvisoryRace.
+type racingLocker struct {
+	AdvisoryLocker
+	races int
+}
+
+func (l *racingLocker) Check(ctx context.Context, conn *pgx.Conn, j *Job) (bool, error) {
+	if l.races > 0 {
+		l.races--
+		return false, nil
+	}
+	return l.AdvisoryLocker.Check(ctx, conn, j)
+}
+
+func TestOnLockRace(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+	c.Locker = &racingLocker{races: 2}
+
+	var races []int
+	c.OnLockRace = func(attempt int) {
+		races = append(races, attempt)
+	}
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	defer j.Done()
+
+	if want := []int{1, 2}; !reflect.DeepEqual(races, want) {
+		t.Errorf("want OnLockRace called with %v, got %v", want, races)
+	}
+}
+
+func TestJobDelete(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	defer j.Done()
+
+	if err = j.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	// make sure job was deleted
+	j2, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j2 != nil {
+		t.Errorf("job was not deleted: %+v", j2)
+	}
+}
+
+func TestJobIsDeleted(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	defer j.Done()
+
+	if j.IsDeleted() {
+		t.Error("want IsDeleted=false before Delete")
+	}
+	if err = j.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if !j.IsDeleted() {
+		t.Error("want IsDeleted=true after Delete")
+	}
+}
+
+func TestJobDeleteAfterContextCancelled(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	for _, typ := range []string{"Deleted", "Failed"} {
+		if err := c.Enqueue(&Job{Type: typ}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	jobs, err := c.LockJobs(ctx, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DoneAll(jobs)
+	if len(jobs) != 2 {
+		t.Fatalf("want 2 jobs, got %d", len(jobs))
+	}
+
+	// Delete and Error do not use the context the job was locked with, so a
+	// WorkFunc can finish its job after the Worker was asked to stop.
+	cancel()
+	if jobs[0].Context().Err() == nil {
+		t.Fatal("want the job's context done")
+	}
+	if err := jobs[0].Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := jobs[1].Error("failed"); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil || j.Type != "Failed" || j.ErrorCount != 1 {
+		t.Errorf("want only the failed job left with an error, got %+v", j)
+	}
+}
+
+func TestJobFinish(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	defer j.Done()
+
+	if err = j.Finish(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !j.IsDeleted() {
+		t.Error("want IsDeleted=true after Finish")
+	}
+	if n := countJobs(t, c.pool); n != 0 {
+		t.Errorf("want the job gone, got %d jobs", n)
+	}
+}
+
+func TestJobDone(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+
+	j.Done()
+
+	// make sure conn and pool were cleared
+	if j.conn != nil {
+		t.Errorf("want nil conn, got %+v", j.conn)
+	}
+	if j.pool != nil {
+		t.Errorf("want nil pool, got %+v", j.pool)
+	}
+
+	// make sure lock was released
+	var count int64
+	query := "SELECT count(*) FROM pg_locks WHERE locktype=$1 AND objid=$2::bigint"
+	if err = c.pool.QueryRow(query, "advisory", j.ID).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("advisory lock was not released")
+	}
+
+	// make sure conn was returned to pool
+	stat := c.pool.Stat()
+	total, available := stat.CurrentConnections, stat.AvailableConnections
+	if total != available {
+		t.Errorf("want available=total, got available=%d total=%d", available, total)
+	}
+}
+
+func TestJobDoneMultiple(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+
+	j.Done()
+	// try calling Done() again
+	j.Done()
+}
+
+func TestJobNotLocked(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	j.Done()
+
+	for name, job := range map[string]*Job{"done": j, "snapshot": {ID: 1, Type: "MyJob"}} {
+		if err := job.Delete(); err != ErrJobNotLocked {
+			t.Errorf("%s: want ErrJobNotLocked from Delete, got %v", name, err)
+		}
+		if err := job.Error("failed"); err != ErrJobNotLocked {
+			t.Errorf("%s: want ErrJobNotLocked from Error, got %v", name, err)
+		}
+		if err := job.Reschedule(context.Background(), time.Now()); err != ErrJobNotLocked {
+			t.Errorf("%s: want ErrJobNotLocked from Reschedule, got %v", name, err)
+		}
+	}
+}
+
+func TestJobDeleteFromTx(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+
+	// get the job's database connection
+	conn := j.Conn()
+	if conn == nil {
+		t.Fatal("wanted conn, got nil")
+	}
+
+	// start a transaction
+	tx, err := conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// delete the job
+	if err = j.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// mark as done
+	j.Done()
+
+	// make sure the job is gone
+	j2, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if j2 != nil {
+		t.Errorf("wanted no job, got %+v", j2)
+	}
+}
+
+func TestJobRunInTx(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	countType := func(jobType string) int {
+		n, err := c.CountByType(context.Background(), "", jobType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	defer j.Done()
+
+	// a failing fn must leave the job and roll back its writes
+	errFailed := errors.New("failed")
+	err = j.RunInTx(context.Background(), func(tx *pgx.Tx) error {
+		if err := c.EnqueueInTx(&Job{Type: "FollowUp"}, tx); err != nil {
+			return err
+		}
+		return errFailed
+	})
+	if err != errFailed {
+		t.Fatalf("want the error of fn, got %v", err)
+	}
+	if n := countType("FollowUp"); n != 0 {
+		t.Errorf("want the writes of fn rolled back, got %d FollowUp jobs", n)
+	}
+	if j.IsDeleted() || countType("MyJob") != 1 {
+		t.Fatal("want the job kept after fn failed")
+	}
+
+	err = j.RunInTx(context.Background(), func(tx *pgx.Tx) error {
+		return c.EnqueueInTx(&Job{Type: "FollowUp"}, tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countType("FollowUp"); n != 1 {
+		t.Errorf("want the writes of fn committed, got %d FollowUp jobs", n)
+	}
+	if !j.IsDeleted() || countType("MyJob") != 0 {
+		t.Error("want the job deleted with the writes of fn")
+	}
+}
+
+func TestJobDeleteFromTxRollback(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	j1, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j1 == nil {
+		t.Fatal("wanted job, got none")
+	}
+
+	// get the job's database connection
+	conn := j1.Conn()
+	if conn == nil {
+		t.Fatal("wanted conn, got nil")
+	}
+
+	// start a transaction
+	tx, err := conn.Begin()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if j == nil {
-		t.Fatal("wanted job, got none")
+
+	// delete the job
+	if err = j1.Delete(); err != nil {
+		t.Fatal(err)
 	}
-	defer j.Done()
 
-	if err = j.Delete(); err != nil {
+	if err = tx.Rollback(); err != nil {
 		t.Fatal(err)
 	}
 
-	// make sure job was deleted
+	// mark as done
+	j1.Done()
+
+	// make sure the job still exists and matches j1
 	j2, err := findOneJob(c.pool)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if j2 != nil {
-		t.Errorf("job was not deleted: %+v", j2)
+
+	if j1.ID != j2.ID {
+		t.Errorf("want job %d, got %d", j1.ID, j2.ID)
 	}
 }
 
-func TestJobDone(t *testing.T) {
+func TestJobError(t *testing.T) {
 	c := openTestClient(t)
 	defer truncateAndClose(c.pool)
 
@@ -427,15 +1422,29 @@ func TestJobDone(t *testing.T) {
 	if j == nil {
 		t.Fatal("wanted job, got none")
 	}
+	defer j.Done()
 
+	msg := "world\nended"
+	if err = j.Error(msg); err != nil {
+		t.Fatal(err)
+	}
 	j.Done()
 
-	// make sure conn and pool were cleared
-	if j.conn != nil {
-		t.Errorf("want nil conn, got %+v", j.conn)
+	// make sure job was not deleted
+	j2, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
 	}
-	if j.pool != nil {
-		t.Errorf("want nil pool, got %+v", j.pool)
+	if j2 == nil {
+		t.Fatal("job was not found")
+	}
+	defer j2.Done()
+
+	if j2.LastError.Status == pgtype.Null || j2.LastError.String != msg {
+		t.Errorf("want LastError=%q, got %q", msg, j2.LastError.String)
+	}
+	if j2.ErrorCount != 1 {
+		t.Errorf("want ErrorCount=%d, got %d", 1, j2.ErrorCount)
 	}
 
 	// make sure lock was released
@@ -456,7 +1465,7 @@ func TestJobDone(t *testing.T) {
 	}
 }
 
-func TestJobDoneMultiple(t *testing.T) {
+func TestJobErrorRunAt(t *testing.T) {
 	c := openTestClient(t)
 	defer truncateAndClose(c.pool)
 
@@ -471,15 +1480,37 @@ func TestJobDoneMultiple(t *testing.T) {
 	if j == nil {
 		t.Fatal("wanted job, got none")
 	}
+	defer j.Done()
 
+	lockedRunAt := j.RunAt
+	if err = j.Error("oops"); err != nil {
+		t.Fatal(err)
+	}
+	if !j.RunAt.After(lockedRunAt) {
+		t.Errorf("want RunAt after Error to be later than %s, got %s", lockedRunAt, j.RunAt)
+	}
+	if j.ErrorCount != 1 {
+		t.Errorf("want ErrorCount=1 on the errored job, got %d", j.ErrorCount)
+	}
 	j.Done()
-	// try calling Done() again
-	j.Done()
+
+	// the job reports the run_at it was rescheduled to
+	j2, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j2 == nil {
+		t.Fatal("job was not found")
+	}
+	if !j2.RunAt.Equal(j.RunAt) {
+		t.Errorf("want RunAt=%s as reported by Error, got %s", j.RunAt, j2.RunAt)
+	}
 }
 
-func TestJobDeleteFromTx(t *testing.T) {
+func TestJobErrorMaxErrorLength(t *testing.T) {
 	c := openTestClient(t)
 	defer truncateAndClose(c.pool)
+	c.MaxErrorLength = 16
 
 	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
 		t.Fatal(err)
@@ -492,97 +1523,269 @@ func TestJobDeleteFromTx(t *testing.T) {
 	if j == nil {
 		t.Fatal("wanted job, got none")
 	}
+	defer j.Done()
 
-	// get the job's database connection
-	conn := j.Conn()
-	if conn == nil {
-		t.Fatal("wanted conn, got nil")
+	if err = j.Error(strings.Repeat("x", 100)); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Repeat("x", 13) + "..."
+	if j.LastError.String != want {
+		t.Errorf("want LastError=%q on the errored job, got %q", want, j.LastError.String)
 	}
+	j.Done()
 
-	// start a transaction
-	tx, err := conn.Begin()
+	j2, err := findOneJob(c.pool)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if j2 == nil {
+		t.Fatal("job was not found")
+	}
+	if j2.LastError.String != want {
+		t.Errorf("want stored LastError=%q, got %q", want, j2.LastError.String)
+	}
+}
 
-	// delete the job
-	if err = j.Delete(); err != nil {
-		t.Fatal(err)
+func TestTruncateError(t *testing.T) {
+	tests := []struct {
+		msg  string
+		max  int
+		want string
+	}{
+		{"boom", 0, "boom"},
+		{"boom", 4, "boom"},
+		{"kaboom", 5, "ka..."},
+		{"kaboom", 2, ".."},
+		{"héllo", 5, "h..."}, // do not split the é
+	}
+	for _, tt := range tests {
+		if got := truncateError(tt.msg, tt.max); got != tt.want {
+			t.Errorf("truncateError(%q, %d) = %q, want %q", tt.msg, tt.max, got, tt.want)
+		}
 	}
+}
 
-	if err = tx.Commit(); err != nil {
-		t.Fatal(err)
+func TestLockJobOrder(t *testing.T) {
+	base := time.Now().Add(-time.Hour)
+	jobs := []*Job{
+		{Type: "LowOld", Priority: 200, RunAt: base},
+		{Type: "HighNew", Priority: 1, RunAt: base.Add(2 * time.Minute), Deadline: time.Now().Add(2 * time.Hour)},
+		{Type: "HighOld", Priority: 1, RunAt: base.Add(time.Minute)},
+		{Type: "LowNew", Priority: 200, RunAt: base.Add(3 * time.Minute), Deadline: time.Now().Add(time.Hour)},
+		{Type: "HighSameRunAtFirst", Priority: 1, RunAt: base.Add(4 * time.Minute)},
+		{Type: "HighSameRunAtSecond", Priority: 1, RunAt: base.Add(4 * time.Minute)},
+		{Type: "Future", Priority: 0, RunAt: time.Now().Add(time.Hour)},
+	}
+	tests := []struct {
+		order LockOrder
+		want  []string
+	}{
+		{LockOrderPriority, []string{"HighOld", "HighNew", "HighSameRunAtFirst", "HighSameRunAtSecond", "LowOld", "LowNew"}},
+		{LockOrderFIFO, []string{"LowOld", "HighOld", "HighNew", "LowNew", "HighSameRunAtFirst", "HighSameRunAtSecond"}},
+		{LockOrderDeadline, []string{"LowNew", "HighNew", "HighOld", "HighSameRunAtFirst", "HighSameRunAtSecond", "LowOld"}},
+	}
+	for _, tt := range tests {
+		func() {
+			// every job stays locked on its own connection
+			c := openTestClientMaxConns(t, len(jobs))
+			defer truncateAndClose(c.pool)
+			c.LockOrder = tt.order
+
+			for _, j := range jobs {
+				if err := c.Enqueue(j); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			peeked, err := c.PeekNext(context.Background(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if peeked == nil || peeked.Type != tt.want[0] {
+				t.Errorf("order %d: want PeekNext to return %s, got %+v", tt.order, tt.want[0], peeked)
+			}
+
+			// keep every job locked so the next LockJob has to skip it
+			var got []string
+			for {
+				j, err := c.LockJob("")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if j == nil {
+					break
+				}
+				defer j.Done()
+				got = append(got, j.Type)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("order %d: want jobs locked in order %v, got %v", tt.order, tt.want, got)
+			}
+		}()
 	}
+}
 
-	// mark as done
+func TestDequeue(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if j, err := c.Dequeue(ctx, ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want DeadlineExceeded from an empty queue, got job %+v and error %v", j, err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	j, err := c.Dequeue(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil || j.Type != "MyJob" {
+		t.Fatalf("want the enqueued job, got %+v", j)
+	}
+	if err = j.Delete(); err != nil {
+		t.Fatal(err)
+	}
 	j.Done()
+}
 
-	// make sure the job is gone
-	j2, err := findOneJob(c.pool)
+func TestPoolStats(t *testing.T) {
+	c := openTestClientMaxConns(t, 3)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+	j, err := c.LockJob("")
 	if err != nil {
 		t.Fatal(err)
 	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
 
-	if j2 != nil {
-		t.Errorf("wanted no job, got %+v", j2)
+	stats := c.PoolStats()
+	if stats.MaxConnections != 3 {
+		t.Errorf("want MaxConnections=3, got %d", stats.MaxConnections)
+	}
+	if busy := stats.CurrentConnections - stats.AvailableConnections; busy != 1 {
+		t.Errorf("want 1 connection held by the locked job, got %d", busy)
+	}
+
+	j.Done()
+	if stats = c.PoolStats(); stats.CurrentConnections != stats.AvailableConnections {
+		t.Errorf("want all connections available after Done, got %+v", stats)
 	}
 }
 
-func TestJobDeleteFromTxRollback(t *testing.T) {
+func TestJobUpdateArgs(t *testing.T) {
 	c := openTestClient(t)
 	defer truncateAndClose(c.pool)
 
-	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+	if err := c.Enqueue(&Job{Type: "MyJob", Args: []byte(`{"done":0}`)}); err != nil {
 		t.Fatal(err)
 	}
 
-	j1, err := c.LockJob("")
+	j, err := c.LockJob("")
 	if err != nil {
 		t.Fatal(err)
 	}
-	if j1 == nil {
+	if j == nil {
 		t.Fatal("wanted job, got none")
 	}
+	runAt := j.RunAt
 
-	// get the job's database connection
-	conn := j1.Conn()
-	if conn == nil {
-		t.Fatal("wanted conn, got nil")
+	checkpoint := []byte(`{"done":500}`)
+	if err = j.UpdateArgs(context.Background(), checkpoint); err != nil {
+		t.Fatal(err)
 	}
+	if !bytes.Equal(j.Args, checkpoint) {
+		t.Errorf("want Args=%s on the job, got %s", checkpoint, j.Args)
+	}
+	if j.ErrorCount != 0 || !j.RunAt.Equal(runAt) {
+		t.Errorf("want ErrorCount and RunAt unchanged, got %d and %s", j.ErrorCount, j.RunAt)
+	}
+	if err = j.Error("try again"); err != nil {
+		t.Fatal(err)
+	}
+	j.Done()
 
-	// start a transaction
-	tx, err := conn.Begin()
+	// relock the job after its retry delay
+	if _, err = c.pool.Exec("UPDATE que_jobs SET run_at = now()"); err != nil {
+		t.Fatal(err)
+	}
+	j, err = c.LockJob("")
 	if err != nil {
 		t.Fatal(err)
 	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	defer j.Done()
+	if !bytes.Equal(j.Args, checkpoint) {
+		t.Errorf("want the saved Args=%s after a retry, got %s", checkpoint, j.Args)
+	}
+	if j.ErrorCount != 1 {
+		t.Errorf("want ErrorCount=1, got %d", j.ErrorCount)
+	}
 
-	// delete the job
-	if err = j1.Delete(); err != nil {
-		t.Fatal(err)
+	if err = (&Job{}).UpdateArgs(context.Background(), checkpoint); err != ErrJobNotLocked {
+		t.Errorf("want ErrJobNotLocked for a job that is not locked, got %v", err)
 	}
+}
 
-	if err = tx.Rollback(); err != nil {
+func TestJobErrorMaxRetryDelay(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	defer func(d time.Duration) { MaxRetryDelay = d }(MaxRetryDelay)
+	MaxRetryDelay = time.Hour
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+	// the default delay function would wait 20^4 seconds, about 2 days
+	if _, err := c.pool.Exec("UPDATE que_jobs SET error_count = 20"); err != nil {
 		t.Fatal(err)
 	}
 
-	// mark as done
-	j1.Done()
-
-	// make sure the job still exists and matches j1
-	j2, err := findOneJob(c.pool)
+	j, err := c.LockJob("")
 	if err != nil {
 		t.Fatal(err)
 	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+	defer j.Done()
 
-	if j1.ID != j2.ID {
-		t.Errorf("want job %d, got %d", j1.ID, j2.ID)
+	if err = j.Error("again"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(j.RunAt); d > time.Hour || d < 59*time.Minute {
+		t.Errorf("want the retry capped at an hour, got RunAt in %s", d)
 	}
 }
 
-func TestJobError(t *testing.T) {
+// fixedClock is a Clock that is stopped at one instant.
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+func TestJobErrorClock(t *testing.T) {
 	c := openTestClient(t)
 	defer truncateAndClose(c.pool)
 
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	c.Clock = fixedClock(now)
+
 	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
 		t.Fatal(err)
 	}
@@ -596,43 +1799,96 @@ func TestJobError(t *testing.T) {
 	}
 	defer j.Done()
 
-	msg := "world\nended"
-	if err = j.Error(msg); err != nil {
+	if err = j.Error("failed"); err != nil {
 		t.Fatal(err)
 	}
-	j.Done()
+	// the default delay function waits 3 seconds after the first error
+	if want := now.Add(3 * time.Second); !j.RunAt.Equal(want) {
+		t.Errorf("want RunAt %s, got %s", want, j.RunAt)
+	}
+}
 
-	// make sure job was not deleted
-	j2, err := findOneJob(c.pool)
+func TestJobErrorMaxRetries(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+	c.SetMaxRetries("Notify", 2)
+
+	for _, typ := range []string{"Notify", "Payment"} {
+		if err := c.Enqueue(&Job{Type: typ}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// both jobs have been retried twice
+	if _, err := c.pool.Exec("UPDATE que_jobs SET error_count = 2"); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := c.LockJobs(context.Background(), "", 2)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if j2 == nil {
-		t.Fatal("job was not found")
+	defer DoneAll(jobs)
+	if len(jobs) != 2 {
+		t.Fatalf("want 2 jobs, got %d", len(jobs))
 	}
-	defer j2.Done()
 
-	if j2.LastError.Status == pgtype.Null || j2.LastError.String != msg {
-		t.Errorf("want LastError=%q, got %q", msg, j2.LastError.String)
+	for _, j := range jobs {
+		if err := j.Error("failed"); err != nil {
+			t.Fatal(err)
+		}
+		if want := j.Type == "Notify"; j.IsDeleted() != want {
+			t.Errorf("want IsDeleted=%v for %s, got %v", want, j.Type, j.IsDeleted())
+		}
 	}
-	if j2.ErrorCount != 1 {
-		t.Errorf("want ErrorCount=%d, got %d", 1, j2.ErrorCount)
+	if n := countJobs(t, c.pool); n != 1 {
+		t.Errorf("want only the Payment job left, got %d jobs", n)
 	}
+}
 
-	// make sure lock was released
-	var count int64
-	query := "SELECT count(*) FROM pg_locks WHERE locktype=$1 AND objid=$2::bigint"
-	if err = c.pool.QueryRow(query, "advisory", j.ID).Scan(&count); err != nil {
+func TestJobErrorRetryPriorityStep(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+	c.RegisterType("Notify", TypeOptions{RetryPriorityStep: 30})
+
+	if err := c.Enqueue(&Job{Type: "Notify", Priority: 50}); err != nil {
 		t.Fatal(err)
 	}
-	if count != 0 {
-		t.Error("advisory lock was not released")
+
+	for _, want := range []int16{20, 0} {
+		if _, err := c.pool.Exec("UPDATE que_jobs SET run_at = now()"); err != nil {
+			t.Fatal(err)
+		}
+		j, err := c.LockJob("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j == nil {
+			t.Fatal("wanted job, got none")
+		}
+		if err := j.Error("failed"); err != nil {
+			t.Fatal(err)
+		}
+		j.Done()
+		if j.Priority != want {
+			t.Errorf("want priority %d after the retry, got %d", want, j.Priority)
+		}
+		d, err := c.GetJob(context.Background(), j.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Priority != want {
+			t.Errorf("want stored priority %d, got %d", want, d.Priority)
+		}
 	}
+}
 
-	// make sure conn was returned to pool
-	stat := c.pool.Stat()
-	total, available := stat.CurrentConnections, stat.AvailableConnections
-	if total != available {
-		t.Errorf("want available=total, got available=%d total=%d", available, total)
+func TestJitter(t *testing.T) {
+	if got := jitter(100, 0); got != 100 {
+		t.Errorf("want no jitter with fraction 0, got %d", got)
+	}
+	for i := 0; i < 1000; i++ {
+		if got := jitter(100, 0.2); got < 80 || got > 120 {
+			t.Fatalf("want jittered delay within [80, 120], got %d", got)
+		}
 	}
 }
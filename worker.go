@@ -2,13 +2,24 @@ package que
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/jackc/pgx"
 )
 
 // WorkFunc is a function that performs a Job. If an error is returned, the job
@@ -19,6 +30,46 @@ type WorkFunc func(j *Job) error
 // given type.
 type WorkMap map[string]WorkFunc
 
+// ErrUnknownType is recorded as the error of a job whose Type is not
+// registered in the Worker's WorkMap.
+var ErrUnknownType = errors.New("unknown job type")
+
+// ErrInvalidArgs is the reason logged for a job discarded because its Args
+// are not valid JSON, see Worker.RejectInvalidArgs.
+var ErrInvalidArgs = errors.New("job args are not valid JSON")
+
+// UnknownTypePolicy decides what a Worker does with a job whose Type is not
+// registered in its WorkMap.
+type UnknownTypePolicy int
+
+const (
+	// UnknownTypeRetry records ErrUnknownType on the job and retries it with
+	// the usual backoff. This is the default.
+	UnknownTypeRetry UnknownTypePolicy = iota
+
+	// UnknownTypeDiscard deletes the job instead of retrying it forever.
+	UnknownTypeDiscard
+)
+
+// PanicPolicy decides what a Worker does with a job whose WorkFunc panicked.
+type PanicPolicy int
+
+const (
+	// PanicRecordError records the panic and its stack trace as the job's
+	// error and retries it with the usual backoff, like a returned error.
+	// This is the default.
+	PanicRecordError PanicPolicy = iota
+
+	// PanicRequeue unlocks the job without counting an error, so it can be
+	// locked again right away. It suits panics caused by the process rather
+	// than the job, but a job that always panics is then retried without
+	// backoff.
+	PanicRequeue
+
+	// PanicDiscard deletes the job.
+	PanicDiscard
+)
+
 // Worker is a single worker that pulls jobs off the specified Queue. If no Job
 // is found, the Worker will sleep for Interval seconds.
 type Worker struct {
@@ -26,20 +77,115 @@ type Worker struct {
 	// to find another Job.
 	Interval time.Duration
 
+	// MaxInterval, if greater than Interval, makes an idle Worker back off: the
+	// sleep doubles after every poll that found no job, up to MaxInterval, and
+	// drops back to Interval as soon as a job is found.
+	MaxInterval time.Duration
+
 	// Queue is the name of the queue to pull Jobs off of. The default value, "",
 	// is usable and is the default for both que-go and the ruby que library.
 	Queue string
 
-	c *Client
-	m WorkMap
+	// AllQueues makes the Worker lock the most urgent job of any queue that
+	// is not paused, as with Client.LockAnyJob, instead of jobs of Queue and
+	// of the queues added with AddQueue. Jobs are worked with the Worker's
+	// WorkMap whatever their queue.
+	AllQueues bool
+
+	// ID identifies this Worker in the locked_by column of the jobs it works,
+	// which helps to find out which process is working a stuck job. It
+	// defaults to the hostname and process ID. An empty ID disables the
+	// extra write.
+	ID string
+
+	// Labels, if set, are written to the worked_by_labels column of the jobs
+	// this Worker works, e.g. the region it runs in, for later analysis of
+	// where jobs ran. Jobs are deleted once they succeed, so the labels stay
+	// on the jobs that are retried or failed for good. They do not affect
+	// which jobs are locked.
+	Labels map[string]string
+
+	// UnknownTypePolicy decides what happens to jobs whose Type is not in the
+	// WorkMap. It defaults to UnknownTypeRetry.
+	UnknownTypePolicy UnknownTypePolicy
+
+	// OnUnknownType, if set, works the jobs whose Type is not in the WorkMap,
+	// like a catch-all WorkFunc: a job is deleted if it returns nil and
+	// retried if it returns an error. Returning an error that wraps
+	// ErrUnknownType applies the UnknownTypePolicy instead, so it can also be
+	// used just to log or count such jobs.
+	OnUnknownType WorkFunc
+
+	// RejectInvalidArgs makes the Worker check that a job's Args are valid
+	// JSON before running its WorkFunc. Jobs with invalid Args are discarded
+	// with ErrInvalidArgs, rather than failing in the WorkFunc and being
+	// retried forever.
+	RejectInvalidArgs bool
+
+	// BatchSize is the number of jobs WorkOne locks and works one after the
+	// other on a single database connection before returning it to the pool.
+	// Each job is still locked individually. Values below 2 lock one job per
+	// connection checkout.
+	BatchSize int
+
+	// PanicHandler, if set, is called with the recovered value and stack trace
+	// when a WorkFunc panics, before the PanicPolicy is applied. It can be
+	// used to report panics to an error tracker.
+	PanicHandler func(j *Job, recovered interface{}, stack []byte)
+
+	// PanicPolicy decides what happens to a job whose WorkFunc panicked. It
+	// defaults to PanicRecordError.
+	PanicPolicy PanicPolicy
+
+	// RateLimits limits how often the jobs of the types it holds are worked,
+	// e.g. to stay within the rate limits of an API. Before running the
+	// WorkFunc of such a job, the Worker waits for its type's RateLimiter.
+	RateLimits map[string]*RateLimiter
+
+	// MaxRateLimitWait, if positive, bounds how long the Worker waits for a
+	// RateLimiter. A job that would wait longer is rescheduled, without
+	// counting as an error, for when the limiter can let it through, so the
+	// Worker can go on with other jobs.
+	MaxRateLimitWait time.Duration
+
+	// OnJobDequeued, if set, is called with every job the Worker locks,
+	// before its WorkFunc, with how long the job waited since its RunAt. The
+	// queue latency it measures grows when there are too few workers. The
+	// wait is computed with the Client's Clock, and is never negative.
+	OnJobDequeued func(j *Job, waited time.Duration)
+
+	// OnJobSuccess, if set, is called with every job whose WorkFunc returned
+	// nil once the job has been deleted, with how long the WorkFunc ran. It
+	// is not called for rescheduled jobs or if the delete fails.
+	OnJobSuccess func(j *Job, dur time.Duration)
+
+	c      *Client
+	m      WorkMap
+	queues []queueWorkMap
+	sem    chan struct{} // shared by the Workers of a WorkerPool with MaxConcurrent
+
+	curInterval  int64 // time.Duration, accessed atomically
+	lastActivity int64 // UnixNano, accessed atomically
 
 	mu   sync.Mutex
 	done bool
 	ch   chan struct{}
+
+	runMu   sync.Mutex    // guards stopped
+	stopped chan struct{} // closed once a run of WorkContext returned
 }
 
 var defaultWakeInterval = 5 * time.Second
 
+// defaultWorkerID is the default Worker ID, "hostname:pid".
+var defaultWorkerID = func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}()
+
 func init() {
 	if v := os.Getenv("QUE_WAKE_INTERVAL"); v != "" {
 		if newInt, err := strconv.Atoi(v); err == nil {
@@ -61,64 +207,490 @@ func NewWorker(c *Client, m WorkMap) *Worker {
 	return &Worker{
 		Interval: defaultWakeInterval,
 		Queue:    os.Getenv("QUE_QUEUE"),
+		ID:       defaultWorkerID,
 		c:        c,
 		m:        m,
 		ch:       make(chan struct{}),
+		stopped:  make(chan struct{}),
 	}
 }
 
 // Work pulls jobs off the Worker's Queue at its Interval. This function only
-// returns after Shutdown() is called, so it should be run in its own goroutine.
+// returns after Shutdown() is called, or if the Client's pool was closed, so it
+// should be run in its own goroutine.
 func (w *Worker) Work() {
+	w.WorkContext(context.Background())
+}
+
+// WorkContext is like Work, but also returns once ctx is done. A done context
+// is treated like a call to Shutdown(): the job in progress is finished and no
+// further jobs are locked.
+//
+// It returns nil when it stopped because of Shutdown, Drain or ctx. It
+// returns an error if the database became permanently unusable, which is the
+// case once the Client's pool has been closed, so a supervisor can tell the
+// two apart. Other errors are logged and retried at the next poll. A lost
+// connection is retried after Interval even if the Worker was backing off.
+//
+// A Worker whose WorkContext returned because ctx was done or with an error
+// can be started again. One that was shut down or drained returns right away.
+func (w *Worker) WorkContext(ctx context.Context) error {
+	w.startRun()
+	defer w.finishRun()
+
+	interval := w.Interval
 	for {
+		w.touch()
+		atomic.StoreInt64(&w.curInterval, int64(interval))
+		if ctx.Err() != nil {
+			log.Println("worker done")
+			return nil
+		}
 		select {
 		case <-w.ch:
 			log.Println("worker done")
-			return
-		case <-time.After(w.Interval):
-			for {
-				if didWork := w.WorkOne(); !didWork {
+			return nil
+		case <-ctx.Done():
+			log.Println("worker done")
+			return nil
+		case <-time.After(interval):
+			found, lostConn := false, false
+			for ctx.Err() == nil {
+				select {
+				case <-w.ch:
+					log.Println("worker done")
+					return nil
+				default:
+				}
+				didWork, err := w.workOne(ctx)
+				w.touch()
+				if err != nil && isFatalWorkerError(err) {
+					log.Printf("worker stopped: %v", err)
+					return err
+				}
+				if err != nil {
+					log.Printf("event=connection_lost retry_in=%s: %v", w.Interval, err)
+					lostConn = true
+					break
+				}
+				if !didWork {
 					break // didn't do any work, go back to sleep
 				}
+				found = true
+			}
+			interval = w.nextInterval(interval, found)
+			if lostConn {
+				// Retry soon instead of backing off, since the queue is
+				// not known to be empty.
+				interval = w.Interval
 			}
 		}
 	}
 }
 
+// LastActivity returns when the Worker's Work loop last made progress: it is
+// updated whenever the loop wakes up and after every attempt to work a job,
+// whether or not one was found. A watchdog can treat a LastActivity older than
+// the current interval plus the longest expected job as a wedged Worker. It is
+// the zero time until Work is started.
+func (w *Worker) LastActivity() time.Time {
+	if ns := atomic.LoadInt64(&w.lastActivity); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+func (w *Worker) touch() {
+	atomic.StoreInt64(&w.lastActivity, w.c.now().UnixNano())
+}
+
+// stoppedChan returns the channel that is closed once the current run of
+// WorkContext, or the next one if none is running yet, returns.
+func (w *Worker) stoppedChan() chan struct{} {
+	w.runMu.Lock()
+	defer w.runMu.Unlock()
+	return w.stopped
+}
+
+// startRun replaces the stopped channel of a previous run of WorkContext.
+func (w *Worker) startRun() {
+	w.runMu.Lock()
+	defer w.runMu.Unlock()
+	select {
+	case <-w.stopped:
+		w.stopped = make(chan struct{})
+	default:
+	}
+}
+
+func (w *Worker) finishRun() {
+	w.runMu.Lock()
+	defer w.runMu.Unlock()
+	close(w.stopped)
+}
+
+// CurrentInterval returns how long the Worker sleeps before its next poll,
+// which is above Interval while it is backing off.
+func (w *Worker) CurrentInterval() time.Duration {
+	if d := time.Duration(atomic.LoadInt64(&w.curInterval)); d != 0 {
+		return d
+	}
+	return w.Interval
+}
+
+// nextInterval returns the sleep after a poll that used interval and did or
+// did not find a job.
+func (w *Worker) nextInterval(interval time.Duration, found bool) time.Duration {
+	if found || w.MaxInterval <= w.Interval {
+		return w.Interval
+	}
+	interval *= 2
+	if interval > w.MaxInterval {
+		interval = w.MaxInterval
+	}
+	return interval
+}
+
+// WorkWithSignals is like WorkContext, but also shuts the Worker down
+// gracefully when the process receives one of signals, SIGINT or SIGTERM if
+// none are given: the job in progress is finished, without cancelling its
+// context, and WorkWithSignals returns nil once it is done. The signals are
+// no longer delivered to the process's default handlers while it runs.
+func (w *Worker) WorkWithSignals(ctx context.Context, signals ...os.Signal) error {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, signals...)
+	defer signal.Stop(sigs)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case sig := <-sigs:
+			log.Printf("worker received %v", sig)
+			w.Shutdown()
+		case <-ctx.Done():
+		}
+	}()
+	return w.WorkContext(ctx)
+}
+
+// WorkOne locks and works a single job from the Worker's Queue. It reports
+// whether a job was found.
 func (w *Worker) WorkOne() (didWork bool) {
-	j, err := w.c.LockJob(w.Queue)
+	return w.WorkOneContext(context.Background())
+}
+
+// WorkOneContext is like WorkOne, but stops trying to lock a job once ctx is
+// done. A done context is not reported as an error.
+func (w *Worker) WorkOneContext(ctx context.Context) (didWork bool) {
+	didWork, _ = w.workOne(ctx)
+	return didWork
+}
+
+// ErrNotLocked is returned by EnqueueAndWork if the job it enqueued could not
+// be locked, because it was skipped as a duplicate of another job with the
+// same IdempotencyKey or because another worker locked it first.
+var ErrNotLocked = errors.New("enqueued job could not be locked")
+
+// EnqueueAndWork enqueues j like Client.EnqueueContext and then works exactly
+// that job in the calling goroutine, returning the error its WorkFunc
+// returned or panicked with, or the error that kept it from running. The job
+// is locked by its ID, so its RunAt, dependencies and a paused queue are not
+// waited for. It uses the WorkMap of the job's queue if it is one of the
+// Worker's, and the Worker's own one otherwise. It is meant for tests and for
+// flows that need a job's result before going on, and can be used on a
+// Worker that is not started.
+func (w *Worker) EnqueueAndWork(ctx context.Context, j *Job) error {
+	if err := w.c.EnqueueContext(ctx, j); err != nil {
+		return err
+	}
+	if j.ID == 0 {
+		return ErrNotLocked
+	}
+
+	locked, err := w.c.LockJobByID(ctx, j.ID)
 	if err != nil {
+		return err
+	}
+	if locked == nil {
+		return ErrNotLocked
+	}
+
+	m := w.m
+	for _, q := range w.queues {
+		if q.queue == locked.Queue && locked.Queue != w.Queue {
+			m = q.m
+			break
+		}
+	}
+	return w.work(locked, m)
+}
+
+// workOne works a job from the first of the Worker's queues that has one
+// ready. It only returns an error if the Worker cannot continue, see
+// isFatalWorkerError, or lost its database connection, see isConnError.
+func (w *Worker) workOne(ctx context.Context) (didWork bool, err error) {
+	if didWork, err = w.workQueue(ctx, w.Queue, w.m); didWork || err != nil || w.AllQueues {
+		return didWork, err
+	}
+	for _, q := range w.queues {
+		if ctx.Err() != nil {
+			return false, nil
+		}
+		if didWork, err = w.workQueue(ctx, q.queue, q.m); didWork || err != nil {
+			return didWork, err
+		}
+	}
+	return false, nil
+}
+
+// isFatalWorkerError reports whether err, returned while locking a job, means
+// that retrying is pointless.
+func isFatalWorkerError(err error) bool {
+	return errors.Is(err, pgx.ErrClosedPool)
+}
+
+// isConnError reports whether err, returned while locking a job, was caused by
+// losing the database connection. The pool discards dead connections when
+// they are released, so locking again with a new one is likely to succeed.
+func isConnError(err error) bool {
+	if errors.Is(err, pgx.ErrDeadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var pgErr pgx.PgError
+	if errors.As(err, &pgErr) {
+		// Class 08 is connection exception, 57P01 to 57P03 are the server
+		// shutting down or not accepting connections yet.
+		return strings.HasPrefix(pgErr.Code, "08") || pgErr.Code == "57P01" || pgErr.Code == "57P02" || pgErr.Code == "57P03"
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// lockFailed handles err, returned while locking a job from workQueue or
+// workBatch. Fatal and connection errors are returned for WorkContext to act
+// on, any other error is logged and swallowed.
+func lockFailed(ctx context.Context, err error) error {
+	if isFatalWorkerError(err) || isConnError(err) {
+		return err
+	}
+	if ctx.Err() == nil {
 		log.Printf("attempting to lock job: %v", err)
-		return
+	}
+	return nil
+}
+
+// queueWorkMap is a queue added to a Worker with AddQueue.
+type queueWorkMap struct {
+	queue string
+	m     WorkMap
+}
+
+// AddQueue makes the Worker also work jobs from queue, using m to perform
+// them. Queues are tried in order on every poll: the Worker's own Queue first,
+// then added queues in the order they were added, so a later queue is only
+// worked while the earlier ones have no jobs ready. AddQueue must be called
+// before the Worker is started.
+func (w *Worker) AddQueue(queue string, m WorkMap) {
+	w.queues = append(w.queues, queueWorkMap{queue: queue, m: m})
+}
+
+// workQueue locks and works one job, or a batch of them if BatchSize is set,
+// from queue using m. It reports whether a job was found, and returns the
+// error of a failed lock only if it is fatal.
+func (w *Worker) workQueue(ctx context.Context, queue string, m WorkMap) (didWork bool, err error) {
+	if w.sem != nil {
+		select {
+		case w.sem <- struct{}{}:
+			defer func() { <-w.sem }()
+		case <-ctx.Done():
+			return false, nil
+		}
+	}
+
+	if w.BatchSize > 1 {
+		return w.workBatch(ctx, queue, m)
+	}
+
+	var j *Job
+	if w.AllQueues {
+		j, err = w.c.LockAnyJob(ctx)
+	} else {
+		j, err = w.c.LockJobContext(ctx, queue)
+	}
+	if err != nil {
+		return false, lockFailed(ctx, err)
 	}
 	if j == nil {
-		return // no job was available
+		return false, nil // no job was available
+	}
+	w.work(j, m)
+	return true, nil
+}
+
+// workBatch locks and works up to BatchSize jobs one after the other on a
+// single connection, returning it to the pool only after the last one. It
+// stops early once the Worker is shut down or drained, so that only the job
+// in progress is finished.
+func (w *Worker) workBatch(ctx context.Context, queue string, m WorkMap) (didWork bool, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	conn, err := w.c.acquire(ctx)
+	if err != nil {
+		return false, lockFailed(ctx, err)
+	}
+	defer w.c.pool.Release(conn)
+
+	stmt, args := w.c.orderedStatement("que_lock_job"), []interface{}{queue}
+	if w.AllQueues {
+		stmt, args = w.c.orderedStatement("que_lock_any_job"), nil
 	}
+	for i := 0; i < w.BatchSize && ctx.Err() == nil; i++ {
+		select {
+		case <-w.ch:
+			return
+		default:
+		}
+		j, err := w.c.lockJobOnConn(ctx, conn, stmt, args...)
+		if err != nil {
+			return didWork, lockFailed(ctx, err)
+		}
+		if j == nil {
+			return didWork, nil // no more jobs available
+		}
+		didWork = true
+		w.work(j, m)
+	}
+	return
+}
+
+// work performs a locked job using m and marks it as done. It returns the
+// error the job failed with, if any.
+func (w *Worker) work(j *Job, m WorkMap) (err error) {
 	defer j.Done()
-	defer recoverPanic(j)
+	defer w.notifyCompletion(j, &err)
+	defer w.recoverPanic(j, &err)
 
-	didWork = true
+	if w.OnJobDequeued != nil {
+		waited := w.c.now().Sub(j.RunAt)
+		if waited < 0 {
+			waited = 0
+		}
+		w.OnJobDequeued(j, waited)
+	}
 
-	wf, ok := w.m[j.Type]
-	if !ok {
-		msg := fmt.Sprintf("unknown job type: %q", j.Type)
-		log.Println(msg)
-		if err = j.Error(msg); err != nil {
-			log.Printf("attempting to save error on job %d: %v", j.ID, err)
+	if w.ID != "" {
+		if err := j.setLockedBy(w.ID); err != nil {
+			log.Printf("attempting to set locked_by on job %d: %v", j.ID, err)
 		}
-		return
+	}
+	if len(w.Labels) != 0 {
+		if err := j.setWorkedByLabels(w.Labels); err != nil {
+			log.Printf("attempting to set worked_by_labels on job %d: %v", j.ID, err)
+		}
+	}
+
+	wf, ok := m[j.Type]
+	if !ok && w.OnUnknownType != nil {
+		wf, ok = w.OnUnknownType, true
+	}
+	if !ok {
+		return w.unknownType(j)
+	}
+
+	if w.RejectInvalidArgs && !json.Valid(j.Args) {
+		w.discard(j, ErrInvalidArgs.Error())
+		return ErrInvalidArgs
 	}
 
-	if err = wf(j); err != nil {
+	if l := w.RateLimits[j.Type]; l != nil && !w.waitRateLimit(j, l) {
+		return nil
+	}
+
+	start := w.c.now()
+	if err := wf(j); err != nil {
+		if errors.Is(err, ErrUnknownType) {
+			return w.unknownType(j)
+		}
 		j.Error(err.Error())
-		return
+		if j.IsDeleted() {
+			log.Printf("event=job_discarded job_id=%d job_type=%s reason=%q", j.ID, j.Type, "max retries exceeded: "+err.Error())
+		}
+		return err
 	}
 
-	if err = j.Delete(); err != nil {
+	if j.isRescheduled() {
+		log.Printf("event=job_rescheduled job_id=%d job_type=%s run_at=%s", j.ID, j.Type, j.RunAt.Format(time.RFC3339))
+		return nil
+	}
+
+	dur := w.c.now().Sub(start)
+
+	if err := j.Delete(); err != nil {
 		log.Printf("attempting to delete job %d: %v", j.ID, err)
+		return err
 	}
 	log.Printf("event=job_worked job_id=%d job_type=%s", j.ID, j.Type)
-	return
+	if w.OnJobSuccess != nil {
+		w.OnJobSuccess(j, dur)
+	}
+	return nil
+}
+
+// waitRateLimit waits until l lets j run and reports whether it may. A job
+// that would wait longer than MaxRateLimitWait is rescheduled instead.
+func (w *Worker) waitRateLimit(j *Job, l *RateLimiter) bool {
+	now := w.c.now()
+	wait, ok := l.reserve(now, w.MaxRateLimitWait)
+	if !ok {
+		runAt := now.Add(wait)
+		if err := j.Reschedule(j.Context(), runAt); err != nil {
+			log.Printf("attempting to reschedule rate limited job %d: %v", j.ID, err)
+			return false
+		}
+		log.Printf("event=job_rate_limited job_id=%d job_type=%s run_at=%s", j.ID, j.Type, runAt.Format(time.RFC3339))
+		return false
+	}
+	if wait <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(wait):
+		return true
+	case <-j.Context().Done():
+		// the Worker is stopping, leave the job for later
+		l.cancel()
+		return false
+	}
+}
+
+// unknownType applies the UnknownTypePolicy to a job whose Type has no
+// WorkFunc and returns an error wrapping ErrUnknownType.
+func (w *Worker) unknownType(j *Job) error {
+	unknown := fmt.Errorf("%w: %q", ErrUnknownType, j.Type)
+	msg := unknown.Error()
+	log.Println(msg)
+	if w.UnknownTypePolicy == UnknownTypeDiscard {
+		w.discard(j, msg)
+		return unknown
+	}
+	if err := j.Error(msg); err != nil {
+		log.Printf("attempting to save error on job %d: %v", j.ID, err)
+	}
+	return unknown
+}
+
+// discard deletes a job that must not be retried, logging why.
+func (w *Worker) discard(j *Job, reason string) {
+	if err := j.Delete(); err != nil {
+		log.Printf("attempting to delete job %d: %v", j.ID, err)
+		return
+	}
+	log.Printf("event=job_discarded job_id=%d job_type=%s reason=%q", j.ID, j.Type, reason)
 }
 
 // Shutdown tells the worker to finish processing its current job and then stop.
@@ -134,27 +706,71 @@ func (w *Worker) Shutdown() {
 	}
 
 	log.Println("worker shutting down gracefully...")
-	w.ch <- struct{}{}
+	select {
+	case w.ch <- struct{}{}:
+	case <-w.stoppedChan():
+		// Work already returned because its context was done.
+	}
 	w.done = true
 	close(w.ch)
 }
 
-// recoverPanic tries to handle panics in job execution.
-// A stacktrace is stored into Job last_error.
-func recoverPanic(j *Job) {
+// ErrDrainTimeout is returned by Drain if the Worker's job in progress did not
+// finish before the context was done.
+var ErrDrainTimeout = errors.New("worker did not finish its job before the drain deadline")
+
+// Drain tells the worker to stop locking new jobs and blocks until the job in
+// progress, if any, has finished or ctx is done. In the latter case
+// ErrDrainTimeout is returned; the abandoned job keeps running in the
+// background and its lock is released once it finishes or the process exits.
+// Like Shutdown, it should only be called on an active Worker.
+func (w *Worker) Drain(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.done {
+		log.Println("worker draining...")
+		w.done = true
+		close(w.ch)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.stoppedChan():
+		return nil
+	case <-ctx.Done():
+		return ErrDrainTimeout
+	}
+}
+
+// recoverPanic tries to handle panics in job execution by applying the
+// PanicPolicy. By default a stacktrace is stored into Job last_error. The
+// panic is also returned from work through err.
+func (w *Worker) recoverPanic(j *Job, err *error) {
 	if r := recover(); r != nil {
+		*err = fmt.Errorf("panic: %v", r)
+
 		// record an error on the job with panic message and stacktrace
 		stackBuf := make([]byte, 1024)
 		n := runtime.Stack(stackBuf, false)
 
+		if w.PanicHandler != nil {
+			w.PanicHandler(j, r, stackBuf[:n])
+		}
+
 		buf := &bytes.Buffer{}
 		fmt.Fprintf(buf, "%v\n", r)
 		fmt.Fprintln(buf, string(stackBuf[:n]))
 		fmt.Fprintln(buf, "[...]")
 		stacktrace := buf.String()
 		log.Printf("event=panic job_id=%d job_type=%s\n%s", j.ID, j.Type, stacktrace)
-		if err := j.Error(stacktrace); err != nil {
-			log.Printf("attempting to save error on job %d: %v", j.ID, err)
+		switch w.PanicPolicy {
+		case PanicRequeue:
+			// Done unlocks the job with its run_at and error_count unchanged
+		case PanicDiscard:
+			w.discard(j, fmt.Sprintf("panic: %v", r))
+		default:
+			if err := j.Error(stacktrace); err != nil {
+				log.Printf("attempting to save error on job %d: %v", j.ID, err)
+			}
 		}
 	}
 }
@@ -166,12 +782,83 @@ type WorkerPool struct {
 	Interval time.Duration
 	Queue    string
 
-	c       *Client
-	workers []*Worker
-	mu      sync.Mutex
-	done    bool
+	// AllQueues is applied to every Worker in the pool.
+	AllQueues bool
+
+	// Labels is set on every Worker in the pool.
+	Labels map[string]string
+
+	// MaxInterval is applied to every Worker in the pool.
+	MaxInterval time.Duration
+
+	// BatchSize is applied to every Worker in the pool.
+	BatchSize int
+
+	// UnknownTypePolicy is applied to every Worker in the pool.
+	UnknownTypePolicy UnknownTypePolicy
+
+	// OnUnknownType is set on every Worker in the pool.
+	OnUnknownType WorkFunc
+
+	// RejectInvalidArgs is applied to every Worker in the pool.
+	RejectInvalidArgs bool
+
+	// MaxConcurrent, if positive, limits how many of the pool's Workers work
+	// jobs at the same time, independently of the number of Workers and of
+	// the size of the Client's connection pool. Workers wait for a free slot
+	// before locking a job, so no job is held locked while waiting.
+	MaxConcurrent int
+
+	// PanicHandler is set on every Worker in the pool.
+	PanicHandler func(j *Job, recovered interface{}, stack []byte)
+
+	// PanicPolicy is applied to every Worker in the pool.
+	PanicPolicy PanicPolicy
+
+	// RateLimits is set on every Worker in the pool, so the pool's Workers
+	// share the limiters.
+	RateLimits map[string]*RateLimiter
+
+	// MaxRateLimitWait is applied to every Worker in the pool.
+	MaxRateLimitWait time.Duration
+
+	// OnJobDequeued is set on every Worker in the pool. It must be safe for
+	// concurrent use.
+	OnJobDequeued func(j *Job, waited time.Duration)
+
+	// OnJobSuccess is set on every Worker in the pool. It must be safe for
+	// concurrent use.
+	OnJobSuccess func(j *Job, dur time.Duration)
+
+	// MaxWorkers, if greater than the count given to NewWorkerPool, makes the
+	// pool autoscale between count and MaxWorkers Workers, as long as
+	// TargetDepthPerWorker is positive. Every AutoscaleInterval the pool
+	// counts the jobs of Queue that are ready and not being worked, and runs
+	// one Worker per TargetDepthPerWorker of them. Scaling down shuts Workers
+	// down gracefully, so no job in progress is interrupted.
+	MaxWorkers int
+
+	// TargetDepthPerWorker is the number of waiting jobs per Worker that
+	// autoscaling aims for, see MaxWorkers.
+	TargetDepthPerWorker int
+
+	// AutoscaleInterval is how often an autoscaling pool adjusts its size. It
+	// defaults to 10 seconds.
+	AutoscaleInterval time.Duration
+
+	c         *Client
+	workers   []*Worker
+	sem       chan struct{}
+	scaleStop chan struct{}
+	scaleDone chan struct{}
+	mu        sync.Mutex
+	done      bool
 }
 
+// defaultAutoscaleInterval is the AutoscaleInterval of a WorkerPool that
+// does not set one.
+const defaultAutoscaleInterval = 10 * time.Second
+
 // NewWorkerPool creates a new WorkerPool with count workers using the Client c.
 func NewWorkerPool(c *Client, wm WorkMap, count int) *WorkerPool {
 	return &WorkerPool{
@@ -182,37 +869,140 @@ func NewWorkerPool(c *Client, wm WorkMap, count int) *WorkerPool {
 	}
 }
 
-// Start starts all of the Workers in the WorkerPool.
+// Start starts all of the Workers in the WorkerPool, and the autoscaling if
+// it is enabled.
 func (w *WorkerPool) Start() {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if w.MaxConcurrent > 0 {
+		w.sem = make(chan struct{}, w.MaxConcurrent)
+	}
 	for i := range w.workers {
-		w.workers[i] = NewWorker(w.c, w.WorkMap)
-		w.workers[i].Interval = w.Interval
-		w.workers[i].Queue = w.Queue
-		go w.workers[i].Work()
+		w.workers[i] = w.startWorker()
+	}
+	if w.MaxWorkers > len(w.workers) && w.TargetDepthPerWorker > 0 {
+		w.scaleStop = make(chan struct{})
+		w.scaleDone = make(chan struct{})
+		go w.autoscale(len(w.workers))
 	}
 }
 
-// Shutdown sends a Shutdown signal to each of the Workers in the WorkerPool and
-// waits for them all to finish shutting down.
-func (w *WorkerPool) Shutdown() {
+// startWorker starts a Worker configured like the pool. The caller must hold
+// w.mu.
+func (w *WorkerPool) startWorker() *Worker {
+	worker := NewWorker(w.c, w.WorkMap)
+	worker.sem = w.sem
+	worker.Interval = w.Interval
+	worker.MaxInterval = w.MaxInterval
+	worker.Queue = w.Queue
+	worker.AllQueues = w.AllQueues
+	worker.Labels = w.Labels
+	worker.BatchSize = w.BatchSize
+	worker.UnknownTypePolicy = w.UnknownTypePolicy
+	worker.OnUnknownType = w.OnUnknownType
+	worker.RejectInvalidArgs = w.RejectInvalidArgs
+	worker.PanicHandler = w.PanicHandler
+	worker.PanicPolicy = w.PanicPolicy
+	worker.OnJobDequeued = w.OnJobDequeued
+	worker.OnJobSuccess = w.OnJobSuccess
+	worker.RateLimits = w.RateLimits
+	worker.MaxRateLimitWait = w.MaxRateLimitWait
+	go worker.Work()
+	return worker
+}
+
+// Size returns the number of Workers the pool is running.
+func (w *WorkerPool) Size() int {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	return len(w.workers)
+}
 
-	if w.done {
-		return
+// autoscale adjusts the number of Workers between minWorkers and MaxWorkers
+// until scaleStop is closed.
+func (w *WorkerPool) autoscale(minWorkers int) {
+	defer close(w.scaleDone)
+
+	interval := w.AutoscaleInterval
+	if interval <= 0 {
+		interval = defaultAutoscaleInterval
 	}
-	var wg sync.WaitGroup
-	wg.Add(len(w.workers))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-	for _, worker := range w.workers {
+	for {
+		select {
+		case <-w.scaleStop:
+			return
+		case <-ticker.C:
+		}
+
+		stats, err := w.c.QueueStats(context.Background(), w.Queue)
+		if err != nil {
+			log.Printf("attempting to autoscale worker pool: %v", err)
+			continue
+		}
+		waiting := stats.Ready - stats.Working
+		want := (waiting + w.TargetDepthPerWorker - 1) / w.TargetDepthPerWorker
+		if want < minWorkers {
+			want = minWorkers
+		}
+		if want > w.MaxWorkers {
+			want = w.MaxWorkers
+		}
+
+		w.mu.Lock()
+		var stopped []*Worker
+		if n := len(w.workers); want > n {
+			for i := n; i < want; i++ {
+				w.workers = append(w.workers, w.startWorker())
+			}
+			log.Printf("event=worker_pool_scaled workers=%d waiting=%d", want, waiting)
+		} else if want < n {
+			stopped = w.workers[want:]
+			w.workers = w.workers[:want:want]
+			log.Printf("event=worker_pool_scaled workers=%d waiting=%d", want, waiting)
+		}
+		w.mu.Unlock()
+
+		// let the removed Workers finish their jobs before scaling again
+		shutdownAll(stopped)
+	}
+}
+
+// shutdownAll shuts down workers concurrently and waits for all of them.
+func shutdownAll(workers []*Worker) {
+	var wg sync.WaitGroup
+	wg.Add(len(workers))
+	for _, worker := range workers {
 		go func(worker *Worker) {
 			worker.Shutdown()
 			wg.Done()
 		}(worker)
 	}
 	wg.Wait()
+}
+
+// Shutdown sends a Shutdown signal to each of the Workers in the WorkerPool and
+// waits for them all to finish shutting down.
+func (w *WorkerPool) Shutdown() {
+	// stop autoscaling first, which waits for the Workers it removed
+	w.mu.Lock()
+	stop := w.scaleStop
+	w.scaleStop = nil
+	w.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-w.scaleDone
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.done {
+		return
+	}
+	shutdownAll(w.workers)
 	w.done = true
 }
@@ -1,13 +1,25 @@
 package que
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/jackc/pgx"
 	"github.com/jackc/pgx/pgtype"
 )
 
@@ -46,6 +58,76 @@ func TestWorkerWorkOne(t *testing.T) {
 	}
 }
 
+func TestWorkerWorkOneBatch(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	worked := 0
+	w := NewWorker(c, WorkMap{
+		"MyJob": func(j *Job) error {
+			worked++
+			return nil
+		},
+	})
+	w.BatchSize = 2
+
+	for i := 0; i < 3; i++ {
+		if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if didWork := w.WorkOne(); !didWork {
+		t.Errorf("want didWork=true")
+	}
+	if worked != 2 {
+		t.Errorf("want 2 jobs worked in one batch, got %d", worked)
+	}
+
+	// make sure the shared conn was returned to the pool
+	stat := c.pool.Stat()
+	if stat.CurrentConnections != stat.AvailableConnections {
+		t.Errorf("want available=total, got available=%d total=%d", stat.AvailableConnections, stat.CurrentConnections)
+	}
+
+	if didWork := w.WorkOne(); !didWork {
+		t.Errorf("want didWork=true")
+	}
+	if worked != 3 {
+		t.Errorf("want 3 jobs worked, got %d", worked)
+	}
+}
+
+func TestWorkerWorkOneBatchDrain(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	worked := 0
+	var w *Worker
+	w = NewWorker(c, WorkMap{
+		"MyJob": func(j *Job) error {
+			worked++
+			// stop locking jobs without waiting for this one
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			w.Drain(ctx)
+			return nil
+		},
+	})
+	w.BatchSize = 10
+
+	for i := 0; i < 3; i++ {
+		if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w.WorkOne()
+	if worked != 1 {
+		t.Errorf("want the batch to stop after the job in progress, got %d jobs worked", worked)
+	}
+}
+
 func TestWorkerShutdown(t *testing.T) {
 	c := openTestClient(t)
 	defer truncateAndClose(c.pool)
@@ -65,6 +147,51 @@ func TestWorkerShutdown(t *testing.T) {
 	}
 }
 
+func TestWorkerIdleSleep(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	var worked int32
+	w := NewWorker(c, WorkMap{"MyJob": func(j *Job) error {
+		atomic.AddInt32(&worked, 1)
+		return nil
+	}})
+	w.Interval = time.Second
+	go w.Work()
+
+	// Wait for the first poll: the loop reports activity before it sleeps
+	// and again after every poll.
+	waitFor := func(cond func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the worker")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor(func() bool { return !w.LastActivity().IsZero() })
+	started := w.LastActivity()
+	waitFor(func() bool { return w.LastActivity().After(started) })
+
+	// An idle Worker sleeps for its Interval instead of polling again.
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt32(&worked); n != 0 {
+		t.Errorf("want no polls during the Interval, got %d jobs worked", n)
+	}
+	waitFor(func() bool { return atomic.LoadInt32(&worked) == 1 })
+
+	// Shutdown interrupts the sleep.
+	start := time.Now()
+	w.Shutdown()
+	if d := time.Since(start); d > w.Interval/2 {
+		t.Errorf("want Shutdown to interrupt the sleep, took %s", d)
+	}
+}
+
 func BenchmarkWorker(b *testing.B) {
 	c := openTestClient(b)
 	log.SetOutput(ioutil.Discard)
@@ -87,6 +214,29 @@ func BenchmarkWorker(b *testing.B) {
 	}
 }
 
+func BenchmarkWorkerBatch(b *testing.B) {
+	c := openTestClient(b)
+	log.SetOutput(ioutil.Discard)
+	defer func() {
+		log.SetOutput(os.Stdout)
+	}()
+	defer truncateAndClose(c.pool)
+
+	w := NewWorker(c, WorkMap{"Nil": nilWorker})
+	w.BatchSize = 10
+
+	for i := 0; i < b.N; i++ {
+		if err := c.Enqueue(&Job{Type: "Nil"}); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i += w.BatchSize {
+		w.WorkOne()
+	}
+}
+
 func nilWorker(j *Job) error {
 	return nil
 }
@@ -142,6 +292,47 @@ func TestWorkerWorkReturnsError(t *testing.T) {
 	}
 }
 
+func TestWorkerWorkOneRescheduled(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	later := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	wm := WorkMap{
+		"MyJob": func(j *Job) error {
+			return j.Reschedule(j.Context(), later)
+		},
+	}
+	w := NewWorker(c, wm)
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+	if !w.WorkOne() {
+		t.Fatal("want didWork=true")
+	}
+
+	j, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("want the rescheduled job to be kept, got none")
+	}
+	if !j.RunAt.Equal(later) {
+		t.Errorf("want RunAt=%s, got %s", later, j.RunAt)
+	}
+	if j.ErrorCount != 0 {
+		t.Errorf("want ErrorCount=0, got %d", j.ErrorCount)
+	}
+	if j.LastError.Status != pgtype.Null {
+		t.Errorf("want no LastError, got %q", j.LastError.String)
+	}
+
+	if w.WorkOne() {
+		t.Error("want the rescheduled job not to be worked before its RunAt")
+	}
+}
+
 func TestWorkerWorkRescuesPanic(t *testing.T) {
 	c := openTestClient(t)
 	defer truncateAndClose(c.pool)
@@ -193,6 +384,272 @@ func TestWorkerWorkRescuesPanic(t *testing.T) {
 	}
 }
 
+func TestWorkerPanicPolicy(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	w := NewWorker(c, WorkMap{
+		"MyJob": func(j *Job) error {
+			panic("the panic msg")
+		},
+	})
+
+	w.PanicPolicy = PanicRequeue
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+	j, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runAt := j.RunAt
+	w.WorkOne()
+
+	if j, err = findOneJob(c.pool); err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("want the job requeued, got none")
+	}
+	if j.ErrorCount != 0 || j.LastError.Status != pgtype.Null {
+		t.Errorf("want no error recorded, got ErrorCount=%d LastError=%q", j.ErrorCount, j.LastError.String)
+	}
+	if !j.RunAt.Equal(runAt) {
+		t.Errorf("want RunAt=%s unchanged, got %s", runAt, j.RunAt)
+	}
+
+	w.PanicPolicy = PanicDiscard
+	w.WorkOne()
+	if j, err = findOneJob(c.pool); err != nil {
+		t.Fatal(err)
+	}
+	if j != nil {
+		t.Errorf("want the job discarded, got %+v", j)
+	}
+}
+
+func TestWorkerOnJobDequeued(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob", RunAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	var dequeued *Job
+	var waited time.Duration
+	called := false
+	w := NewWorker(c, WorkMap{
+		"MyJob": func(j *Job) error {
+			called = true
+			if dequeued != j {
+				t.Errorf("want OnJobDequeued called with the job before the WorkFunc")
+			}
+			return nil
+		},
+	})
+	w.OnJobDequeued = func(j *Job, d time.Duration) {
+		dequeued, waited = j, d
+	}
+
+	if !w.WorkOne() || !called {
+		t.Fatal("want the job worked")
+	}
+	if waited < time.Minute || waited > time.Hour {
+		t.Errorf("want waited of about a minute, got %s", waited)
+	}
+}
+
+func TestWorkerOnJobSuccess(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	for _, typ := range []string{"Succeeds", "Fails"} {
+		if err := c.Enqueue(&Job{Type: typ}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var succeeded []string
+	var dur time.Duration
+	w := NewWorker(c, WorkMap{
+		"Succeeds": func(j *Job) error {
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		},
+		"Fails": func(j *Job) error {
+			return errors.New("failed")
+		},
+	})
+	w.OnJobSuccess = func(j *Job, d time.Duration) {
+		succeeded = append(succeeded, j.Type)
+		dur = d
+	}
+
+	for w.WorkOne() {
+	}
+	if len(succeeded) != 1 || succeeded[0] != "Succeeds" {
+		t.Fatalf("want OnJobSuccess called for Succeeds only, got %v", succeeded)
+	}
+	if dur < 10*time.Millisecond {
+		t.Errorf("want a duration of at least 10ms, got %s", dur)
+	}
+}
+
+func TestWorkerCompletionWebhook(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	var notices []CompletionNotice
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var n CompletionNotice
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Error(err)
+		}
+		notices = append(notices, n)
+	}))
+	defer srv.Close()
+
+	ok := &Job{Type: "Succeeds", CompletionWebhook: srv.URL}
+	for _, j := range []*Job{ok, {Type: "Succeeds"}} {
+		if err := c.Enqueue(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := NewWorker(c, WorkMap{
+		"Succeeds":            func(j *Job) error { return nil },
+		CompletionWebhookType: CompletionWebhookWorkFunc(srv.Client()),
+	})
+	for w.WorkOne() {
+	}
+
+	want := []CompletionNotice{{JobID: ok.ID, JobType: "Succeeds", Status: JobSucceeded}}
+	if !reflect.DeepEqual(notices, want) {
+		t.Errorf("want notices %+v, got %+v", want, notices)
+	}
+	if n := countJobs(t, c.pool); n != 0 {
+		t.Errorf("want no jobs left, got %d", n)
+	}
+}
+
+func TestWorkerEnqueueAndWork(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob", Priority: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	var worked []int64
+	failed := errors.New("failed")
+	w := NewWorker(c, WorkMap{
+		"MyJob": func(j *Job) error {
+			worked = append(worked, j.ID)
+			return nil
+		},
+		"Fails": func(j *Job) error {
+			return failed
+		},
+	})
+
+	j := &Job{Type: "MyJob", Priority: 100, RunAt: time.Now().Add(time.Hour)}
+	if err := w.EnqueueAndWork(context.Background(), j); err != nil {
+		t.Fatal(err)
+	}
+	if len(worked) != 1 || worked[0] != j.ID {
+		t.Fatalf("want job %d worked, got %v", j.ID, worked)
+	}
+	if n := countJobs(t, c.pool); n != 1 {
+		t.Errorf("want the other job left, got %d jobs", n)
+	}
+
+	if err := w.EnqueueAndWork(context.Background(), &Job{Type: "Fails"}); err != failed {
+		t.Errorf("want the WorkFunc's error, got %v", err)
+	}
+}
+
+func TestWorkerRateLimits(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	for i := 0; i < 2; i++ {
+		if err := c.Enqueue(&Job{Type: "SendSMS"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	called := 0
+	w := NewWorker(c, WorkMap{
+		"SendSMS": func(j *Job) error {
+			called++
+			return nil
+		},
+	})
+	w.RateLimits = map[string]*RateLimiter{"SendSMS": NewRateLimiter(1.0/60, 1)}
+	w.MaxRateLimitWait = time.Second
+
+	for i := 0; i < 2; i++ {
+		if !w.WorkOne() {
+			t.Fatal("want a job locked")
+		}
+	}
+	if called != 1 {
+		t.Errorf("want the WorkFunc called once within the limit, got %d", called)
+	}
+
+	j, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("want the rate limited job left in the queue")
+	}
+	if j.ErrorCount != 0 {
+		t.Errorf("want the rate limited job not errored, got ErrorCount=%d", j.ErrorCount)
+	}
+	if d := time.Until(j.RunAt); d < 50*time.Second || d > 70*time.Second {
+		t.Errorf("want the rate limited job rescheduled about a minute later, got RunAt in %s", d)
+	}
+}
+
+func TestWorkerPanicHandler(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	var recovered interface{}
+	var stack []byte
+	w := NewWorker(c, WorkMap{
+		"MyJob": func(j *Job) error {
+			panic("the panic msg")
+		},
+	})
+	w.PanicHandler = func(j *Job, r interface{}, s []byte) {
+		recovered = r
+		stack = s
+	}
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+	w.WorkOne()
+
+	if recovered != "the panic msg" {
+		t.Errorf("want recovered=%q, got %v", "the panic msg", recovered)
+	}
+	if !strings.Contains(string(stack), "worker_test.go:") {
+		t.Errorf("want stack to contain \"worker_test.go:\", got %q", stack)
+	}
+
+	j, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(j.LastError.String, "the panic msg\n") {
+		t.Errorf("want LastError contains \"the panic msg\\n\" was: %q", j.LastError.String)
+	}
+}
+
 func TestWorkerWorkOneTypeNotInMap(t *testing.T) {
 	c := openTestClient(t)
 	defer truncateAndClose(c.pool)
@@ -249,3 +706,714 @@ func TestWorkerWorkOneTypeNotInMap(t *testing.T) {
 	}
 
 }
+
+func TestWorkerUnknownTypeError(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+
+	w := NewWorker(c, WorkMap{})
+	if err = w.work(j, w.m); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("want ErrUnknownType, got %v", err)
+	}
+
+	j2, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j2 == nil {
+		t.Fatal("job was not found")
+	}
+	if !strings.HasPrefix(j2.LastError.String, ErrUnknownType.Error()) {
+		t.Errorf("want LastError to start with ErrUnknownType, got %q", j2.LastError.String)
+	}
+}
+
+func TestWorkerWorkOneTypeNotInMapDiscard(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	w := NewWorker(c, WorkMap{})
+	w.UnknownTypePolicy = UnknownTypeDiscard
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if didWork := w.WorkOne(); !didWork {
+		t.Errorf("want didWork=true")
+	}
+
+	j, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j != nil {
+		t.Errorf("want job of unknown type to be discarded, got %+v", j)
+	}
+}
+
+func TestWorkerOnUnknownType(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	var seen []string
+	w := NewWorker(c, WorkMap{})
+	w.UnknownTypePolicy = UnknownTypeDiscard
+	w.OnUnknownType = func(j *Job) error {
+		seen = append(seen, j.Type)
+		if j.Type == "Legacy" {
+			return nil // handled by the catch-all
+		}
+		return fmt.Errorf("%w: %s", ErrUnknownType, j.Type)
+	}
+
+	for _, typ := range []string{"Legacy", "Obsolete"} {
+		if err := c.Enqueue(&Job{Type: typ}); err != nil {
+			t.Fatal(err)
+		}
+		if didWork := w.WorkOne(); !didWork {
+			t.Errorf("want didWork=true for %s", typ)
+		}
+	}
+
+	if want := []string{"Legacy", "Obsolete"}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("want OnUnknownType called for %v, got %v", want, seen)
+	}
+	// Legacy was worked and Obsolete fell back to the discard policy
+	j, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j != nil {
+		t.Errorf("want no jobs left, got %+v", j)
+	}
+}
+
+func TestWorkerRejectInvalidArgs(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	called := false
+	w := NewWorker(c, WorkMap{
+		"MyJob": func(j *Job) error {
+			called = true
+			return nil
+		},
+	})
+	w.RejectInvalidArgs = true
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+	j, err := c.LockJob("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("wanted job, got none")
+	}
+
+	// the json column rejects malformed input, so break the args after
+	// locking, as a truncated read would
+	j.Args = []byte(`{"name":`)
+	w.work(j, w.m)
+
+	if called {
+		t.Error("want WorkFunc not to be called for invalid args")
+	}
+	remaining, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != nil {
+		t.Errorf("want job with invalid args to be discarded, got %+v", remaining)
+	}
+}
+
+func TestWorkerDrain(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	w := NewWorker(c, WorkMap{
+		"MyJob": func(j *Job) error {
+			close(started)
+			<-release
+			return nil
+		},
+	})
+	w.Interval = 10 * time.Millisecond
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+	go w.Work()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := w.Drain(ctx); err != ErrDrainTimeout {
+		t.Fatalf("want ErrDrainTimeout while the job is running, got %v", err)
+	}
+
+	close(release)
+	if err := w.Drain(context.Background()); err != nil {
+		t.Fatalf("want nil once the job finished, got %v", err)
+	}
+
+	j, err := findOneJob(c.pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j != nil {
+		t.Errorf("want drained job to be completed, got %+v", j)
+	}
+}
+
+func TestWorkerWorkContextCancelled(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	w := NewWorker(c, WorkMap{})
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- w.WorkContext(ctx)
+	}()
+	cancel()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("want nil after the context was cancelled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("want WorkContext to return after its context is cancelled")
+	}
+
+	// Shutdown must not block on a worker whose context already stopped it.
+	w.Shutdown()
+	if !w.done {
+		t.Errorf("want w.done=true")
+	}
+}
+
+func TestWorkerWorkContextRestart(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	w := NewWorker(c, WorkMap{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := w.WorkContext(ctx); err != nil {
+		t.Fatalf("want nil after the context was cancelled, got %v", err)
+	}
+
+	// a stopped Worker can be started again and shut down
+	result := make(chan error, 1)
+	go func() {
+		result <- w.WorkContext(context.Background())
+	}()
+	w.Shutdown()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("want nil after Shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the restarted WorkContext to return after Shutdown")
+	}
+}
+
+func TestWorkerWorkContextClosedPool(t *testing.T) {
+	c := openTestClient(t)
+	c.pool.Close()
+
+	w := NewWorker(c, WorkMap{})
+	w.Interval = time.Millisecond
+	result := make(chan error, 1)
+	go func() {
+		result <- w.WorkContext(context.Background())
+	}()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, pgx.ErrClosedPool) {
+			t.Errorf("want ErrClosedPool, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WorkContext did not return after the pool was closed")
+	}
+}
+
+func TestWorkerWorkContextLostConn(t *testing.T) {
+	c := openTestClientMaxConns(t, 1)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// kill the pool's only connection behind its back
+	conn, err := c.pool.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid := conn.PID()
+	c.pool.Release(conn)
+
+	admin, err := pgx.Connect(testConnConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	if _, err := admin.Exec("SELECT pg_terminate_backend($1)", int32(pid)); err != nil {
+		t.Fatal(err)
+	}
+
+	worked := make(chan struct{})
+	w := NewWorker(c, WorkMap{
+		"MyJob": func(j *Job) error {
+			close(worked)
+			return nil
+		},
+	})
+	w.Interval = 10 * time.Millisecond
+	w.MaxInterval = time.Minute
+	result := make(chan error, 1)
+	go func() {
+		result <- w.WorkContext(context.Background())
+	}()
+
+	select {
+	case <-worked:
+	case err := <-result:
+		t.Fatalf("WorkContext returned after losing its connection: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("job was not worked after reconnecting")
+	}
+	w.Shutdown()
+	if err := <-result; err != nil {
+		t.Errorf("want nil after Shutdown, got %v", err)
+	}
+}
+
+func TestIsConnError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{pgx.ErrDeadConn, true},
+		{fmt.Errorf("locking: %w", io.EOF), true},
+		{pgx.PgError{Code: "57P01"}, true},
+		{pgx.PgError{Code: "08006"}, true},
+		{pgx.PgError{Code: "57014"}, false},
+		{pgx.ErrClosedPool, false},
+		{errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		if got := isConnError(tt.err); got != tt.want {
+			t.Errorf("isConnError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestWorkerWorkWithSignals(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var jobErr error
+	w := NewWorker(c, WorkMap{
+		"MyJob": func(j *Job) error {
+			// the worker is running, so the signal handler is installed
+			p, err := os.FindProcess(os.Getpid())
+			if err == nil {
+				err = p.Signal(syscall.SIGHUP)
+			}
+			if err != nil {
+				return err
+			}
+			select {
+			case <-j.Context().Done():
+				jobErr = errors.New("job context cancelled by the signal")
+			case <-time.After(100 * time.Millisecond):
+			}
+			return nil
+		},
+	})
+	w.Interval = time.Millisecond
+
+	result := make(chan error, 1)
+	go func() {
+		result <- w.WorkWithSignals(context.Background(), syscall.SIGHUP)
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("want nil after the signal, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WorkWithSignals did not return after the signal")
+	}
+	if jobErr != nil {
+		t.Error(jobErr)
+	}
+	if j, err := findOneJob(c.pool); err != nil || j != nil {
+		t.Errorf("want the job in progress finished, got %+v (err %v)", j, err)
+	}
+}
+
+func TestWorkerWorkOneContextCancelled(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	called := false
+	w := NewWorker(c, WorkMap{
+		"MyJob": func(j *Job) error {
+			called = true
+			return nil
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if didWork := w.WorkOneContext(ctx); didWork {
+		t.Errorf("want didWork=false with a cancelled context")
+	}
+	if called {
+		t.Errorf("want handler not to be called with a cancelled context")
+	}
+}
+
+func TestWorkerJobContext(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	var info JobInfo
+	var ok bool
+	logJob := func(ctx context.Context) {
+		info, ok = FromContext(ctx)
+	}
+	w := NewWorker(c, WorkMap{
+		"MyJob": func(j *Job) error {
+			logJob(j.Context())
+			return nil
+		},
+	})
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+	if didWork := w.WorkOne(); !didWork {
+		t.Fatal("want didWork=true")
+	}
+
+	if !ok {
+		t.Fatal("want JobInfo in the job's context")
+	}
+	if info.ID == 0 {
+		t.Error("want non-zero ID")
+	}
+	if want := "MyJob"; info.Type != want {
+		t.Errorf("want Type=%q, got %q", want, info.Type)
+	}
+}
+
+func TestWorkerNextInterval(t *testing.T) {
+	w := NewWorker(nil, WorkMap{})
+	w.Interval = time.Second
+
+	if got := w.nextInterval(time.Second, false); got != time.Second {
+		t.Errorf("want no backoff without MaxInterval, got %s", got)
+	}
+
+	w.MaxInterval = 5 * time.Second
+	interval := w.Interval
+	for _, want := range []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		interval = w.nextInterval(interval, false)
+		if interval != want {
+			t.Errorf("want %s, got %s", want, interval)
+		}
+	}
+	if got := w.nextInterval(interval, true); got != w.Interval {
+		t.Errorf("want reset to %s after finding a job, got %s", w.Interval, got)
+	}
+}
+
+func TestWorkerCurrentInterval(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	w := NewWorker(c, WorkMap{})
+	w.Interval = 10 * time.Millisecond
+	w.MaxInterval = time.Second
+	if got := w.CurrentInterval(); got != w.Interval {
+		t.Errorf("want %s before Work, got %s", w.Interval, got)
+	}
+
+	go w.Work()
+	defer w.Shutdown()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for w.CurrentInterval() <= w.Interval {
+		if time.Now().After(deadline) {
+			t.Fatal("want idle worker to back off")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWorkerLastActivity(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	w := NewWorker(c, WorkMap{})
+	w.Interval = 10 * time.Millisecond
+	if got := w.LastActivity(); !got.IsZero() {
+		t.Errorf("want zero LastActivity before Work, got %s", got)
+	}
+
+	go w.Work()
+	defer w.Shutdown()
+
+	// an idle worker must keep reporting activity
+	start := time.Now()
+	deadline := start.Add(5 * time.Second)
+	for !w.LastActivity().After(start.Add(50 * time.Millisecond)) {
+		if time.Now().After(deadline) {
+			t.Fatalf("want LastActivity to advance, got %s", w.LastActivity())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWorkerAddQueue(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	var worked []string
+	w := NewWorker(c, WorkMap{
+		"A": func(j *Job) error {
+			worked = append(worked, "A")
+			return nil
+		},
+	})
+	w.Queue = "a"
+	w.AddQueue("b", WorkMap{
+		"B": func(j *Job) error {
+			worked = append(worked, "B")
+			return nil
+		},
+	})
+
+	if err := c.Enqueue(&Job{Type: "B", Queue: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Enqueue(&Job{Type: "A", Queue: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for w.WorkOne() {
+	}
+
+	if want := []string{"A", "B"}; !reflect.DeepEqual(worked, want) {
+		t.Errorf("want jobs worked in queue order %v, got %v", want, worked)
+	}
+}
+
+func TestWorkerLockedBy(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	var lockedBy pgtype.Text
+	w := NewWorker(c, WorkMap{
+		"MyJob": func(j *Job) error {
+			err := c.pool.QueryRow("SELECT locked_by FROM que_jobs WHERE job_id = $1", j.ID).Scan(&lockedBy)
+			if err != nil {
+				t.Error(err)
+			}
+			return fmt.Errorf("retry later")
+		},
+	})
+	w.ID = "test-host:1234"
+
+	if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+		t.Fatal(err)
+	}
+	w.WorkOne()
+
+	if lockedBy.String != w.ID {
+		t.Errorf("want locked_by=%q while working, got %q", w.ID, lockedBy.String)
+	}
+
+	err := c.pool.QueryRow("SELECT locked_by FROM que_jobs").Scan(&lockedBy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lockedBy.Status != pgtype.Null {
+		t.Errorf("want locked_by cleared after the job is done, got %q", lockedBy.String)
+	}
+}
+
+func TestWorkerLabels(t *testing.T) {
+	c := openTestClient(t)
+	defer truncateAndClose(c.pool)
+
+	w := NewWorker(c, WorkMap{
+		"MyJob": func(j *Job) error {
+			return fmt.Errorf("retry later")
+		},
+	})
+	w.Labels = map[string]string{"region": "eu-west-1"}
+
+	j := &Job{Type: "MyJob"}
+	if err := c.Enqueue(j); err != nil {
+		t.Fatal(err)
+	}
+	w.WorkOne()
+
+	d, err := c.GetJob(context.Background(), j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d.WorkedByLabels, w.Labels) {
+		t.Errorf("want worked_by_labels %v kept after the retry, got %v", w.Labels, d.WorkedByLabels)
+	}
+}
+
+func TestWorkerPoolMaxConcurrent(t *testing.T) {
+	c := openTestClientMaxConns(t, 10)
+	defer truncateAndClose(c.pool)
+
+	const jobs = 6
+	var mu sync.Mutex
+	running, maxRunning, worked := 0, 0, 0
+	wm := WorkMap{
+		"MyJob": func(j *Job) error {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+
+			time.Sleep(50 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			worked++
+			mu.Unlock()
+			return nil
+		},
+	}
+	for i := 0; i < jobs; i++ {
+		if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pool := NewWorkerPool(c, wm, 4)
+	pool.Interval = 10 * time.Millisecond
+	pool.MaxConcurrent = 2
+	pool.Start()
+	defer pool.Shutdown()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		done := worked == jobs
+		mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the jobs to be worked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxRunning > 2 {
+		t.Errorf("want at most 2 jobs running at once, got %d", maxRunning)
+	}
+}
+
+func TestWorkerPoolAutoscale(t *testing.T) {
+	c := openTestClientMaxConns(t, 10)
+	defer truncateAndClose(c.pool)
+
+	release := make(chan struct{})
+	wm := WorkMap{
+		"MyJob": func(j *Job) error {
+			<-release
+			return nil
+		},
+	}
+	for i := 0; i < 8; i++ {
+		if err := c.Enqueue(&Job{Type: "MyJob"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pool := NewWorkerPool(c, wm, 1)
+	pool.Interval = 10 * time.Millisecond
+	pool.MaxWorkers = 3
+	pool.TargetDepthPerWorker = 2
+	pool.AutoscaleInterval = 10 * time.Millisecond
+	pool.Start()
+	defer pool.Shutdown()
+
+	waitForSize := func(want int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for pool.Size() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %d workers, have %d", want, pool.Size())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// 7 waiting jobs want 4 workers, capped at MaxWorkers
+	waitForSize(3)
+
+	// scaling down lets the blocked jobs finish instead of interrupting them
+	close(release)
+	waitForSize(1)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n, err := c.CountByType(context.Background(), "", "MyJob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the jobs to be worked, %d left", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}